@@ -0,0 +1,63 @@
+// Filename: cmd/api/background.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// backgroundTasks keeps track of the goroutines started with background
+type backgroundTasks struct {
+	ctx    context.Context // cancelled when serve() shuts down
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackgroundTasks() *backgroundTasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundTasks{ctx: ctx, cancel: cancel}
+}
+
+// background runs task in its own goroutine. The task must return soon
+// after ctx is cancelled, which happens once serve() has finished the
+// in-flight requests; serve() then waits for it. A panic is logged
+// instead of taking the whole server down.
+func (a *application) background(name string, task func(ctx context.Context)) {
+	a.tasks.wg.Add(1)
+
+	go func() {
+		defer a.tasks.wg.Done()
+		defer func() {
+			err := recover()
+			if err != nil {
+				a.metrics.panics.Inc()
+				a.logger.Error("background task panicked", "task", name,
+					"error", fmt.Errorf("%v", err), "stack", string(debug.Stack()))
+			}
+		}()
+
+		task(a.tasks.ctx)
+		a.logger.Info("background task stopped", "task", name)
+	}()
+}
+
+// stopBackground cancels the background tasks and waits for them to
+// return, or for ctx to expire
+func (a *application) stopBackground(ctx context.Context) error {
+	a.tasks.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		a.tasks.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background tasks still running: %w", ctx.Err())
+	}
+}
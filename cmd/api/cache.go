@@ -0,0 +1,102 @@
+// Filename: cmd/api/cache.go
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mickali02/qod/internal/cache"
+	"github.com/mickali02/qod/internal/data"
+	"github.com/mickali02/qod/internal/events"
+	"github.com/mickali02/qod/internal/metrics"
+)
+
+// setupCache puts an in-memory cache in front of the comment store. It
+// must run after the metrics and the event bus have been created.
+func (a *application) setupCache() {
+	if !a.config.cache.enabled {
+		return
+	}
+
+	store := cache.NewMemory(a.config.cache.size)
+	cached := &data.CachedCommentStore{
+		Store: a.commentModel,
+		Cache: store,
+		TTL:   a.config.cache.ttl,
+		Observe: func(kind string, result string) {
+			a.metrics.cache.Inc(kind, result)
+		},
+		OnError: func(err error) {
+			a.logger.Warn("comment cache error", "error", err)
+		},
+	}
+
+	a.commentModel = cached
+
+	a.metrics.registry.Register(metrics.NewGaugeFunc("qod_cache_entries", "Entries in the comment cache.",
+		func() float64 { return float64(store.Len()) }))
+
+	// Drop what other instances change. If we may have missed changes,
+	// start again with an empty cache.
+	if a.events != nil {
+		sub := a.events.Subscribe(256)
+		a.background("cache invalidator", func(ctx context.Context) {
+			defer sub.Unsubscribe()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-sub.C:
+					switch event.Type {
+					case events.Resync:
+						store.Clear()
+					default:
+						cached.Invalidate(ctx, event.CommentID)
+					}
+				}
+			}
+		})
+	}
+}
+
+// cacheControl adds the Cache-Control header configured for route (see
+// -cache-control) to successful responses. Errors are never cached.
+func (a *application) cacheControl(route string, next http.HandlerFunc) http.HandlerFunc {
+	policy := a.config.cache.control[route]
+	if policy == "" {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(&cacheControlWriter{ResponseWriter: w, policy: policy}, r)
+	}
+}
+
+// cacheControlWriter sets Cache-Control once it sees a 200 response,
+// unless the handler chose its own
+type cacheControlWriter struct {
+	http.ResponseWriter
+	policy      string
+	wroteHeader bool
+}
+
+func (cw *cacheControlWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if status == http.StatusOK && cw.Header().Get("Cache-Control") == "" {
+			cw.Header().Set("Cache-Control", cw.policy)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheControlWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
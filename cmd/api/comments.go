@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/mickali02/qod/internal/data"
 	"github.com/mickali02/qod/internal/validator"
@@ -38,7 +39,40 @@ func (a *application) createCommentHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = a.commentModel.Insert(comment)
+	// Run the comment past the moderator before saving it. Rejected
+	// comments are never stored; held comments go into the queue.
+	decision := a.moderator.Moderate(comment)
+	if decision.Action == data.ActionReject {
+		a.failedValidationResponse(w, r, map[string]string{"content": decision.Reason})
+		return
+	}
+	comment.Status = decision.Status()
+
+	// Each author has their own posting quota on top of the per-IP limits.
+	// The author name is whatever the client sent, so the quota is kept
+	// per author and IP: nobody can use up someone else's quota by posting
+	// under their name. A client that keeps changing name still gets a new
+	// quota each time; only the per-IP write limit holds it back. Only
+	// comments that will be published count: nobody sees rejected or
+	// held ones, so they can't be used to flood the site.
+	if a.config.limiter.enabled && comment.Status == data.StatusPublished {
+		ip, err := a.clientIP(r)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		result, err := a.limiters.author.Allow(r.Context(), strings.ToLower(comment.Author)+"|"+ip)
+		if err != nil {
+			a.logError(r, err)
+		} else if !result.Allowed {
+			a.metrics.rateLimited.Inc("author")
+			setRateLimitHeaders(w, result)
+			a.rateLimitExceededResponse(w, r, result.RetryAfter)
+			return
+		}
+	}
+
+	err = a.commentModel.Insert(r.Context(), comment)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -47,8 +81,14 @@ func (a *application) createCommentHandler(w http.ResponseWriter, r *http.Reques
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/comments/%d", comment.ID))
 
+	// A comment held for review has been accepted but is not visible yet
+	status := http.StatusCreated
+	if comment.Status == data.StatusPending {
+		status = http.StatusAccepted
+	}
+
 	response := envelope{"comment": comment}
-	err = a.writeJSON(w, http.StatusCreated, response, headers)
+	err = a.writeJSON(w, status, response, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -61,7 +101,7 @@ func (a *application) displayCommentHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	comment, err := a.commentModel.Get(id)
+	comment, err := a.commentModel.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -72,6 +112,12 @@ func (a *application) displayCommentHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Comments waiting for (or refused by) moderation are not public
+	if comment.Status != data.StatusPublished {
+		a.notFoundResponse(w, r)
+		return
+	}
+
 	response := envelope{"comment": comment}
 	err = a.writeJSON(w, http.StatusOK, response, nil)
 	if err != nil {
@@ -87,7 +133,7 @@ func (a *application) updateCommentHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	comment, err := a.commentModel.Get(id)
+	comment, err := a.commentModel.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -98,6 +144,13 @@ func (a *application) updateCommentHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Only published comments can be edited. Anything else is waiting for
+	// (or was refused by) a moderator, and an edit must not get round that.
+	if comment.Status != data.StatusPublished {
+		a.notFoundResponse(w, r)
+		return
+	}
+
 	var incomingData struct {
 		Content *string `json:"content"`
 		Author  *string `json:"author"`
@@ -125,7 +178,15 @@ func (a *application) updateCommentHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = a.commentModel.Update(comment)
+	// Edits go through moderation again
+	decision := a.moderator.Moderate(comment)
+	if decision.Action == data.ActionReject {
+		a.failedValidationResponse(w, r, map[string]string{"content": decision.Reason})
+		return
+	}
+	comment.Status = decision.Status()
+
+	err = a.commentModel.Update(r.Context(), comment)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -146,7 +207,7 @@ func (a *application) deleteCommentHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = a.commentModel.Delete(id)
+	err = a.commentModel.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -198,7 +259,7 @@ func (a *application) listCommentsHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Call the GetAll() method to retrieve the comments.
-	comments, metdata, err := a.commentModel.GetAll(queryParametersData.Content, queryParametersData.Author, queryParametersData.Filters)
+	comments, metdata, err := a.commentModel.GetAll(r.Context(), queryParametersData.Content, queryParametersData.Author, data.StatusPublished, queryParametersData.Filters)
 
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
@@ -0,0 +1,391 @@
+// Filename: cmd/api/comments_test.go
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mickali02/qod/internal/data"
+	"github.com/mickali02/qod/internal/limiter"
+)
+
+// commentResponse is the body of the single comment endpoints
+type commentResponse struct {
+	Comment data.Comment `json:"comment"`
+}
+
+func TestCreateComment(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       any
+		wantStatus int
+		wantState  string // the stored comment's status, if one is stored
+		wantError  string
+	}{
+		{
+			name:       "published",
+			body:       map[string]string{"content": "Live laugh love", "author": "Mickali"},
+			wantStatus: http.StatusCreated,
+			wantState:  data.StatusPublished,
+		},
+		{
+			name:       "held for review",
+			body:       map[string]string{"content": "see www.a.com www.b.com www.c.com", "author": "Mickali"},
+			wantStatus: http.StatusAccepted,
+			wantState:  data.StatusPending,
+		},
+		{
+			name:       "rejected by moderation",
+			body:       map[string]string{"content": "what a load of bullshit", "author": "Mickali"},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "missing content",
+			body:       map[string]string{"author": "Mickali"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "author too long",
+			body:       map[string]string{"content": "hello", "author": strings.Repeat("a", 26)},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown field",
+			body:       map[string]string{"content": "hello", "author": "Mickali", "rating": "5"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "badly-formed JSON",
+			body:       `{"content": "hello",`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "body over the route's limit",
+			body:       `{"content": "hello", "author": "Mickali"` + strings.Repeat(" ", 5000) + `}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "body must not be larger than 4096 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			res := app.do(t, http.MethodPost, "/v1/comments", tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("status = %d; want %d (%s)", res.status, tt.wantStatus, res.body)
+			}
+			if tt.wantError != "" && !strings.Contains(string(res.body), tt.wantError) {
+				t.Errorf("body = %s; want error %q", res.body, tt.wantError)
+			}
+
+			stored, err := app.commentModel.Get(t.Context(), 1)
+			if tt.wantState == "" {
+				if err == nil {
+					t.Errorf("a comment was stored: %+v", stored)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.wantState {
+				t.Errorf("stored status = %q; want %q", stored.Status, tt.wantState)
+			}
+
+			var body commentResponse
+			res.decode(t, &body)
+			if body.Comment.ID != 1 || body.Comment.Version != 1 || body.Comment.Status != tt.wantState {
+				t.Errorf("response comment = %+v", body.Comment)
+			}
+			if location := res.header.Get("Location"); location != "/v1/comments/1" {
+				t.Errorf("Location = %q", location)
+			}
+		})
+	}
+}
+
+func TestCreateCommentAuthorQuota(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.limiters.read = limiter.NewMemory(100, 100)
+	app.limiters.write = limiter.NewMemory(100, 100)
+	app.limiters.author = limiter.NewMemory(1.0/60, 2)
+
+	// Rejected and held comments don't use up the quota...
+	posts := []struct {
+		content    string
+		wantStatus int
+	}{
+		{"what a load of bullshit", http.StatusUnprocessableEntity},
+		{"see www.a.com www.b.com www.c.com", http.StatusAccepted},
+		{"first", http.StatusCreated},
+		{"see www.a.com www.b.com www.c.com", http.StatusAccepted},
+		{"second", http.StatusCreated},
+		// ...but published ones do
+		{"third", http.StatusTooManyRequests},
+	}
+	for _, post := range posts {
+		res := app.do(t, http.MethodPost, "/v1/comments", map[string]string{"content": post.content, "author": "Mickali"})
+		if res.status != post.wantStatus {
+			t.Fatalf("posting %q: status = %d; want %d (%s)", post.content, res.status, post.wantStatus, res.body)
+		}
+	}
+
+	// The quota belongs to the author
+	res := app.do(t, http.MethodPost, "/v1/comments", map[string]string{"content": "hello", "author": "Sam"})
+	if res.status != http.StatusCreated {
+		t.Errorf("another author: status = %d; want 201 (%s)", res.status, res.body)
+	}
+}
+
+func TestDisplayComment(t *testing.T) {
+	app := newTestApplication(t)
+	app.addComment(t, "Published", "Mickali", data.StatusPublished)
+	app.addComment(t, "Waiting", "Mickali", data.StatusPending)
+	app.addComment(t, "Refused", "Mickali", data.StatusRejected)
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{"published", "/v1/comments/1", http.StatusOK},
+		{"pending is hidden", "/v1/comments/2", http.StatusNotFound},
+		{"rejected is hidden", "/v1/comments/3", http.StatusNotFound},
+		{"missing", "/v1/comments/99", http.StatusNotFound},
+		{"zero", "/v1/comments/0", http.StatusNotFound},
+		{"not a number", "/v1/comments/abc", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodGet, tt.target, nil)
+			if res.status != tt.wantStatus {
+				t.Fatalf("status = %d; want %d (%s)", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				if cc := res.header.Get("Cache-Control"); cc != "" {
+					t.Errorf("error response has Cache-Control %q", cc)
+				}
+				return
+			}
+
+			var body commentResponse
+			res.decode(t, &body)
+			if body.Comment.Content != "Published" || body.Comment.Author != "Mickali" {
+				t.Errorf("comment = %+v", body.Comment)
+			}
+			if cc := res.header.Get("Cache-Control"); cc != "public, max-age=5" {
+				t.Errorf("Cache-Control = %q", cc)
+			}
+		})
+	}
+}
+
+func TestUpdateComment(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string // of the stored comment
+		body        any
+		wantStatus  int
+		wantContent string
+		wantAuthor  string
+		wantState   string
+		wantVersion int32
+	}{
+		{
+			name: "content only", status: data.StatusPublished,
+			body:       map[string]string{"content": "Edited"},
+			wantStatus: http.StatusOK, wantContent: "Edited", wantAuthor: "Mickali",
+			wantState: data.StatusPublished, wantVersion: 2,
+		},
+		{
+			name: "author only", status: data.StatusPublished,
+			body:       map[string]string{"author": "Sam"},
+			wantStatus: http.StatusOK, wantContent: "Original", wantAuthor: "Sam",
+			wantState: data.StatusPublished, wantVersion: 2,
+		},
+		{
+			name: "edit is held for review", status: data.StatusPublished,
+			body:       map[string]string{"content": "www.a.com www.b.com www.c.com"},
+			wantStatus: http.StatusOK, wantContent: "www.a.com www.b.com www.c.com", wantAuthor: "Mickali",
+			wantState: data.StatusPending, wantVersion: 2,
+		},
+		{
+			name: "edit is rejected", status: data.StatusPublished,
+			body:       map[string]string{"content": "bullshit"},
+			wantStatus: http.StatusUnprocessableEntity, wantContent: "Original", wantAuthor: "Mickali",
+			wantState: data.StatusPublished, wantVersion: 1,
+		},
+		{
+			name: "empty content", status: data.StatusPublished,
+			body:       map[string]string{"content": ""},
+			wantStatus: http.StatusBadRequest, wantContent: "Original", wantAuthor: "Mickali",
+			wantState: data.StatusPublished, wantVersion: 1,
+		},
+		{
+			// an empty edit must not publish a comment a moderator is holding
+			name: "pending comment", status: data.StatusPending,
+			body:       map[string]string{},
+			wantStatus: http.StatusNotFound, wantContent: "Original", wantAuthor: "Mickali",
+			wantState: data.StatusPending, wantVersion: 1,
+		},
+		{
+			name: "rejected comment", status: data.StatusRejected,
+			body:       map[string]string{"content": "Let me back in"},
+			wantStatus: http.StatusNotFound, wantContent: "Original", wantAuthor: "Mickali",
+			wantState: data.StatusRejected, wantVersion: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.addComment(t, "Original", "Mickali", tt.status)
+
+			res := app.do(t, http.MethodPatch, "/v1/comments/1", tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("status = %d; want %d (%s)", res.status, tt.wantStatus, res.body)
+			}
+			if res.status == http.StatusNotFound && strings.Contains(string(res.body), "Original") {
+				t.Errorf("404 response shows the hidden comment: %s", res.body)
+			}
+
+			stored, err := app.commentModel.Get(t.Context(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Content != tt.wantContent || stored.Author != tt.wantAuthor ||
+				stored.Status != tt.wantState || stored.Version != tt.wantVersion {
+				t.Errorf("stored comment = %+v", stored)
+			}
+		})
+	}
+}
+
+func TestUpdateMissingComment(t *testing.T) {
+	app := newTestApplication(t)
+
+	res := app.do(t, http.MethodPatch, "/v1/comments/7", map[string]string{"content": "Edited"})
+	if res.status != http.StatusNotFound {
+		t.Errorf("status = %d; want 404", res.status)
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	app := newTestApplication(t)
+	app.addComment(t, "Doomed", "Mickali", data.StatusPublished)
+
+	res := app.do(t, http.MethodDelete, "/v1/comments/1", nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d; want 200 (%s)", res.status, res.body)
+	}
+	if res := app.do(t, http.MethodGet, "/v1/comments/1", nil); res.status != http.StatusNotFound {
+		t.Errorf("GET after delete: status = %d; want 404", res.status)
+	}
+	if res := app.do(t, http.MethodDelete, "/v1/comments/1", nil); res.status != http.StatusNotFound {
+		t.Errorf("second delete: status = %d; want 404", res.status)
+	}
+	if res := app.do(t, http.MethodDelete, "/v1/comments/abc", nil); res.status != http.StatusNotFound {
+		t.Errorf("delete with a bad ID: status = %d; want 404", res.status)
+	}
+}
+
+func TestListComments(t *testing.T) {
+	app := newTestApplication(t)
+	app.addComment(t, "The quick brown fox", "Zoe", data.StatusPublished)   // 1
+	app.addComment(t, "A lazy dog sleeps", "Adam", data.StatusPublished)    // 2
+	app.addComment(t, "Quick thinking wins", "Mia", data.StatusPublished)   // 3
+	app.addComment(t, "Held for review, quick", "Zoe", data.StatusPending)  // 4
+	app.addComment(t, "Brown bread is best", "Bob", data.StatusPublished)   // 5
+	app.addComment(t, "Rejected but quick", "Spammer", data.StatusRejected) // 6
+	app.addComment(t, "Quick brown sauce", "Zoe", data.StatusPublished)     // 7
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int64
+		wantMeta   data.Metadata
+	}{
+		{
+			name: "defaults", query: "",
+			wantStatus: http.StatusOK, wantIDs: []int64{1, 2, 3, 5, 7},
+			wantMeta: data.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+		},
+		{
+			name: "content search", query: "content=quick",
+			wantStatus: http.StatusOK, wantIDs: []int64{1, 3, 7},
+			wantMeta: data.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 3},
+		},
+		{
+			name: "content and author", query: "content=brown&author=zoe",
+			wantStatus: http.StatusOK, wantIDs: []int64{1, 7},
+			wantMeta: data.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 2},
+		},
+		{
+			name: "sort by id descending", query: "sort=-id",
+			wantStatus: http.StatusOK, wantIDs: []int64{7, 5, 3, 2, 1},
+			wantMeta: data.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+		},
+		{
+			name: "sort by author, ties by id", query: "sort=author",
+			wantStatus: http.StatusOK, wantIDs: []int64{2, 5, 3, 1, 7},
+			wantMeta: data.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+		},
+		{
+			name: "sort by author descending", query: "sort=-author",
+			wantStatus: http.StatusOK, wantIDs: []int64{1, 7, 3, 5, 2},
+			wantMeta: data.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+		},
+		{
+			name: "second page", query: "page=2&page_size=2",
+			wantStatus: http.StatusOK, wantIDs: []int64{3, 5},
+			wantMeta: data.Metadata{CurrentPage: 2, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5},
+		},
+		{
+			name: "page past the end", query: "page=9&page_size=2",
+			wantStatus: http.StatusOK, wantIDs: []int64{},
+			wantMeta: data.Metadata{},
+		},
+		{name: "sort not allowed", query: "sort=content", wantStatus: http.StatusUnprocessableEntity},
+		{name: "page zero", query: "page=0", wantStatus: http.StatusUnprocessableEntity},
+		{name: "page not a number", query: "page=abc", wantStatus: http.StatusUnprocessableEntity},
+		{name: "page size too big", query: "page_size=101", wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.do(t, http.MethodGet, "/v1/comments?"+tt.query, nil)
+			if res.status != tt.wantStatus {
+				t.Fatalf("status = %d; want %d (%s)", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Comments []data.Comment `json:"comments"`
+				Metadata data.Metadata  `json:"@metadata"`
+			}
+			res.decode(t, &body)
+
+			ids := []int64{}
+			for _, comment := range body.Comments {
+				if comment.Status != data.StatusPublished {
+					t.Errorf("listed a %s comment: %+v", comment.Status, comment)
+				}
+				ids = append(ids, comment.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("got IDs %v; want %v", ids, tt.wantIDs)
+			}
+			if body.Metadata != tt.wantMeta {
+				t.Errorf("got metadata %+v; want %+v", body.Metadata, tt.wantMeta)
+			}
+		})
+	}
+}
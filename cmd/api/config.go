@@ -0,0 +1,335 @@
+// Filename: cmd/api/config.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mickali02/qod/internal/config"
+	"github.com/mickali02/qod/internal/validator"
+)
+
+// envPrefix is put in front of a flag's name to get its environment
+// variable, e.g. -db-dsn can be set with QOD_DB_DSN
+const envPrefix = "QOD_"
+
+// Where a setting came from, in increasing order of precedence
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// envName returns the environment variable for a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyConfigLayers fills in every flag that wasn't given on the command
+// line, first from the environment and then from the config file. It
+// returns where each setting came from.
+func applyConfigLayers(fs *flag.FlagSet, configFile string) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = sourceDefault
+	})
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = sourceFlag
+	})
+
+	if configFile == "" {
+		configFile = os.Getenv(envPrefix + "CONFIG")
+	}
+
+	var fileValues map[string]string
+	if configFile != "" {
+		var err error
+		fileValues, err = config.ReadFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		// A typo in the file should not be silently ignored
+		for name := range fileValues {
+			if fs.Lookup(name) == nil {
+				return nil, fmt.Errorf("config: %s: unknown setting %q", configFile, name)
+			}
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if sources[f.Name] == sourceFlag {
+			return
+		}
+
+		if value, found := os.LookupEnv(envName(f.Name)); found {
+			err := fs.Set(f.Name, value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), err))
+			}
+			sources[f.Name] = sourceEnv
+			return
+		}
+
+		if value, found := fileValues[f.Name]; found {
+			err := fs.Set(f.Name, value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %s in %s: %w", value, f.Name, configFile, err))
+			}
+			sources[f.Name] = sourceFile
+		}
+	})
+
+	return sources, errors.Join(errs...)
+}
+
+// validateConfig checks the whole configuration before anything starts
+func validateConfig(cfg configuration) error {
+	v := validator.New()
+
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(cfg.server.idleTimeout > 0, "server-idle-timeout", "must be greater than zero")
+	v.Check(cfg.server.readTimeout > 0, "server-read-timeout", "must be greater than zero")
+	v.Check(cfg.server.readHeaderTimeout > 0, "server-read-header-timeout", "must be greater than zero")
+	v.Check(cfg.server.readHeaderTimeout <= cfg.server.readTimeout, "server-read-header-timeout", "must not be more than server-read-timeout")
+	v.Check(cfg.server.writeTimeout > 0, "server-write-timeout", "must be greater than zero")
+	v.Check(cfg.server.maxHeaderBytes >= 4096, "server-max-header-bytes", "must be at least 4096")
+	v.Check(cfg.server.shutdownTimeout > 0, "shutdown-timeout", "must be greater than zero")
+	v.Check(cfg.drainDelay >= 0, "shutdown-drain-delay", "must not be negative")
+	v.Check(cfg.server.maxBodyBytes > 0, "max-body-bytes", "must be greater than zero")
+	for route, limit := range cfg.server.bodyLimits {
+		v.Check(strings.HasPrefix(route, "/"), "body-limits", "routes must start with /")
+		v.Check(limit > 0, "body-limits", "limits must be greater than zero")
+	}
+
+	v.Check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-key", "must be set together with tls-cert")
+	if cfg.tls.selfSigned {
+		v.Check(cfg.env == "development", "tls-self-signed", "may only be used in development")
+		v.Check(cfg.tls.certFile == "", "tls-self-signed", "must not be used with tls-cert")
+	}
+	if cfg.tls.redirectPort != 0 {
+		v.Check(cfg.tls.redirectPort > 0 && cfg.tls.redirectPort <= 65535, "tls-redirect-port", "must be between 1 and 65535")
+		v.Check(cfg.tls.redirectPort != cfg.port, "tls-redirect-port", "must not be the same as port")
+		v.Check(cfg.tlsEnabled(), "tls-redirect-port", "needs tls-cert or tls-self-signed")
+	}
+	v.Check(cfg.tls.hstsMaxAge >= 0, "hsts-max-age", "must not be negative")
+
+	v.Check(validator.PermittedValue(cfg.log.format, "", "text", "json"), "log-format", "must be text or json")
+	v.Check(validator.PermittedValue(cfg.log.level, "", "debug", "info", "warn", "error"), "log-level", "must be debug, info, warn or error")
+	v.Check(cfg.log.maxSizeMB > 0, "log-max-size", "must be greater than zero")
+	v.Check(cfg.log.maxBackups >= 0, "log-max-backups", "must not be negative")
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.queryTimeout > 0, "db-query-timeout", "must be greater than zero")
+	v.Check(cfg.db.maxOpenConns >= 0, "db-max-open-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	if cfg.db.maxOpenConns > 0 {
+		v.Check(cfg.db.maxIdleConns <= cfg.db.maxOpenConns, "db-max-idle-conns", "must not be more than db-max-open-conns")
+	}
+	v.Check(cfg.db.maxIdleTime >= 0, "db-max-idle-time", "must not be negative")
+	v.Check(cfg.db.maxLifetime >= 0, "db-max-lifetime", "must not be negative")
+	v.Check(cfg.db.pingRetries >= 0, "db-ping-retries", "must not be negative")
+	v.Check(cfg.db.statsInterval >= 0, "db-stats-interval", "must not be negative")
+	v.Check(cfg.db.replica.checkInterval > 0, "db-replica-check-interval", "must be greater than zero")
+	v.Check(cfg.db.replica.stickiness >= 0, "db-replica-stickiness", "must not be negative")
+
+	v.Check(validator.PermittedValue(cfg.otel.exporter, "none", "stdout", "otlp"), "otel-exporter", "must be none, stdout or otlp")
+	if cfg.otel.exporter == "otlp" {
+		v.Check(cfg.otel.endpoint != "", "otel-endpoint", "must be provided for the otlp exporter")
+	}
+
+	v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+	v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	v.Check(cfg.limiter.write.rps > 0, "limiter-write-rps", "must be greater than zero")
+	v.Check(cfg.limiter.write.burst > 0, "limiter-write-burst", "must be greater than zero")
+	v.Check(cfg.limiter.authorPerMinute >= 0, "limiter-author-per-minute", "must not be negative")
+	v.Check(validator.PermittedValue(cfg.limiter.backend, "memory", "postgres"), "limiter-backend", "must be memory or postgres")
+
+	if cfg.admin.token != "" {
+		v.Check(len(cfg.admin.token) >= 16, "admin-token", "must be at least 16 characters long")
+	}
+
+	v.Check(cfg.moderation.maxLinks >= 0, "moderation-max-links", "must not be negative")
+	v.Check(cfg.moderation.maxRepeated >= 0, "moderation-max-repeated", "must not be negative")
+	v.Check(cfg.reports.hideThreshold >= 0, "reports-hide-threshold", "must not be negative")
+
+	v.Check(cfg.stream.heartbeat > 0, "stream-heartbeat", "must be greater than zero")
+	v.Check(cfg.stream.retention >= 0, "stream-retention", "must not be negative")
+
+	v.Check(cfg.ws.pingInterval > 0, "ws-ping-interval", "must be greater than zero")
+
+	if cfg.cache.enabled {
+		v.Check(cfg.cache.size > 0, "cache-size", "must be greater than zero")
+		v.Check(cfg.cache.ttl > 0, "cache-ttl", "must be greater than zero")
+	}
+	for route := range cfg.cache.control {
+		v.Check(strings.HasPrefix(route, "/"), "cache-control", "routes must start with /")
+	}
+
+	if cfg.webhooks.enabled {
+		v.Check(cfg.webhooks.workers > 0, "webhooks-workers", "must be greater than zero")
+		v.Check(cfg.webhooks.maxAttempts > 0, "webhooks-max-attempts", "must be greater than zero")
+		v.Check(cfg.webhooks.timeout > 0, "webhooks-timeout", "must be greater than zero")
+		v.Check(cfg.webhooks.backoff > 0, "webhooks-backoff", "must be greater than zero")
+		v.Check(cfg.webhooks.maxBackoff >= cfg.webhooks.backoff, "webhooks-max-backoff", "must not be less than webhooks-backoff")
+		v.Check(cfg.webhooks.pollInterval > 0, "webhooks-poll-interval", "must be greater than zero")
+	}
+	v.Check(cfg.webhooks.retention >= 0, "webhooks-retention", "must not be negative")
+
+	if cfg.jobs.enabled {
+		v.Check(cfg.jobs.workers > 0, "jobs-workers", "must be greater than zero")
+		v.Check(cfg.jobs.pollInterval > 0, "jobs-poll-interval", "must be greater than zero")
+	}
+
+	if v.IsEmpty() {
+		return nil
+	}
+
+	// Report the problems in a stable order
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	problems := make([]string, 0, len(keys))
+	for _, key := range keys {
+		problems = append(problems, fmt.Sprintf("%s %s", key, v.Errors[key]))
+	}
+	return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+}
+
+// printConfig writes the effective value of every setting and where it
+// came from. Secrets are masked.
+func printConfig(w io.Writer, fs *flag.FlagSet, sources map[string]string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(w, "%-28s = %-40q # %s\n", f.Name, maskSetting(f.Name, f.Value.String()), sources[f.Name])
+	})
+}
+
+// maskSetting hides passwords in DSNs and the whole value of anything
+// that looks like a secret
+func maskSetting(name string, value string) string {
+	if value == "" {
+		return value
+	}
+	for key := range sensitiveKeys {
+		if strings.Contains(name, key) {
+			return "[REDACTED]"
+		}
+	}
+	return redactString(value)
+}
+
+// stringList is a flag holding a space separated list
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(val string) error {
+	*l = strings.Fields(val)
+	return nil
+}
+
+// networkList is a flag holding space separated CIDR ranges
+type networkList []*net.IPNet
+
+func (l *networkList) String() string {
+	networks := make([]string, 0, len(*l))
+	for _, network := range *l {
+		networks = append(networks, network.String())
+	}
+	return strings.Join(networks, " ")
+}
+
+func (l *networkList) Set(val string) error {
+	networks, err := parseTrustedProxies(val)
+	if err != nil {
+		return err
+	}
+	*l = networks
+	return nil
+}
+
+// cachePolicies is a flag holding a Cache-Control value for each route,
+// written as "/v1/comments=public, max-age=5;/v1/comments/:id=no-cache".
+// The routes it names replace their defaults; other routes keep theirs.
+// A route with an empty value gets no header.
+type cachePolicies map[string]string
+
+func (p *cachePolicies) String() string {
+	policies := make([]string, 0, len(*p))
+	for _, route := range slices.Sorted(maps.Keys(*p)) {
+		policies = append(policies, route+"="+(*p)[route])
+	}
+	return strings.Join(policies, ";")
+}
+
+func (p *cachePolicies) Set(val string) error {
+	policies := make(map[string]string)
+	maps.Copy(policies, *p)
+	for _, policy := range strings.Split(val, ";") {
+		if strings.TrimSpace(policy) == "" {
+			continue
+		}
+		route, directives, found := strings.Cut(policy, "=")
+		if !found {
+			return fmt.Errorf("%q should look like route=directives", policy)
+		}
+		policies[strings.TrimSpace(route)] = strings.TrimSpace(directives)
+	}
+	*p = policies
+	return nil
+}
+
+// bodyLimits is a flag holding the largest JSON body for each route,
+// written as "/v1/comments=4096;/v1/webhooks=8192". The routes it names
+// replace their defaults; other routes keep theirs. A route with an
+// empty value falls back to -max-body-bytes.
+type bodyLimits map[string]int64
+
+func (l *bodyLimits) String() string {
+	limits := make([]string, 0, len(*l))
+	for _, route := range slices.Sorted(maps.Keys(*l)) {
+		limits = append(limits, route+"="+strconv.FormatInt((*l)[route], 10))
+	}
+	return strings.Join(limits, ";")
+}
+
+func (l *bodyLimits) Set(val string) error {
+	limits := make(map[string]int64)
+	maps.Copy(limits, *l)
+	for _, limit := range strings.Split(val, ";") {
+		if strings.TrimSpace(limit) == "" {
+			continue
+		}
+		route, size, found := strings.Cut(limit, "=")
+		if !found {
+			return fmt.Errorf("%q should look like route=bytes", limit)
+		}
+		if strings.TrimSpace(size) == "" {
+			delete(limits, strings.TrimSpace(route))
+			continue
+		}
+		bytes, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q: the limit must be a number of bytes", limit)
+		}
+		limits[strings.TrimSpace(route)] = bytes
+	}
+	*l = limits
+	return nil
+}
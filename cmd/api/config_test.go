@@ -0,0 +1,196 @@
+// Filename: cmd/api/config_test.go
+package main
+
+import (
+	"flag"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestFlagSet returns a flag set with four settings that each come
+// from a different layer in TestApplyConfigLayers
+func newTestFlagSet(t *testing.T) (*flag.FlagSet, map[string]*string) {
+	t.Helper()
+
+	fs := flag.NewFlagSet("qod", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	values := make(map[string]*string)
+	for _, name := range []string{"env", "db-dsn", "cors-trusted-origins", "log-level"} {
+		values[name] = fs.String(name, "default", "")
+	}
+	return fs, values
+}
+
+// writeConfigFile writes content to a config file with the given name
+func writeConfigFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyConfigLayers(t *testing.T) {
+	path := writeConfigFile(t, "qod.yaml", `
+env: from-file
+db:
+  dsn: from-file
+cors:
+  trusted_origins: from-file
+`)
+	t.Setenv("QOD_DB_DSN", "from-env")
+	t.Setenv("QOD_CORS_TRUSTED_ORIGINS", "from-env")
+
+	fs, values := newTestFlagSet(t)
+	err := fs.Parse([]string{"-cors-trusted-origins=from-flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := applyConfigLayers(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// file < env < flag, and the default when nothing sets it
+	tests := []struct {
+		name       string
+		wantValue  string
+		wantSource string
+	}{
+		{"env", "from-file", sourceFile},
+		{"db-dsn", "from-env", sourceEnv},
+		{"cors-trusted-origins", "from-flag", sourceFlag},
+		{"log-level", "default", sourceDefault},
+	}
+	for _, tt := range tests {
+		if got := *values[tt.name]; got != tt.wantValue {
+			t.Errorf("%s = %q; want %q", tt.name, got, tt.wantValue)
+		}
+		if got := sources[tt.name]; got != tt.wantSource {
+			t.Errorf("%s came from %q; want %q", tt.name, got, tt.wantSource)
+		}
+	}
+}
+
+func TestApplyConfigLayersFileFromEnv(t *testing.T) {
+	path := writeConfigFile(t, "qod.toml", "env = \"from-file\"\n")
+	t.Setenv("QOD_CONFIG", path)
+
+	fs, values := newTestFlagSet(t)
+	sources, err := applyConfigLayers(fs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *values["env"] != "from-file" || sources["env"] != sourceFile {
+		t.Errorf("env = %q from %q; want it from QOD_CONFIG's file", *values["env"], sources["env"])
+	}
+}
+
+func TestApplyConfigLayersErrors(t *testing.T) {
+	t.Run("unknown setting", func(t *testing.T) {
+		path := writeConfigFile(t, "qod.yaml", "db:\n  dns: typo\n")
+		fs, _ := newTestFlagSet(t)
+
+		_, err := applyConfigLayers(fs, path)
+		if err == nil || !strings.Contains(err.Error(), `unknown setting "db-dns"`) {
+			t.Errorf("got error %v", err)
+		}
+	})
+
+	t.Run("invalid values name their layer", func(t *testing.T) {
+		path := writeConfigFile(t, "qod.yaml", "port: many\n")
+		t.Setenv("QOD_WORKERS", "lots")
+
+		fs := flag.NewFlagSet("qod", flag.ContinueOnError)
+		fs.Int("port", 4000, "")
+		fs.Int("workers", 1, "")
+
+		_, err := applyConfigLayers(fs, path)
+		if err == nil {
+			t.Fatal("got no error")
+		}
+		for _, want := range []string{`"many" for port in ` + path, `"lots" for QOD_WORKERS`} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q does not mention %s", err, want)
+			}
+		}
+	})
+}
+
+func TestCachePoliciesSet(t *testing.T) {
+	policies := cachePolicies{
+		"/v1/comments":     "public, max-age=5",
+		"/v1/comments/:id": "public, max-age=5",
+	}
+
+	err := policies.Set("/v1/comments/:id=no-cache; /v1/healthcheck=no-store;/v1/comments=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := cachePolicies{
+		"/v1/comments":     "",
+		"/v1/comments/:id": "no-cache",
+		"/v1/healthcheck":  "no-store",
+	}
+	if !maps.Equal(policies, want) {
+		t.Errorf("got %v; want %v", policies, want)
+	}
+
+	if err := policies.Set("no-equals-sign"); err == nil {
+		t.Error("Set accepted a policy without a route")
+	}
+}
+
+func TestBodyLimitsSet(t *testing.T) {
+	limits := bodyLimits{
+		"/v1/comments":             4096,
+		"/v1/comments/:id":         4096,
+		"/v1/comments/:id/reports": 4096,
+	}
+
+	err := limits.Set("/v1/comments=2048; /v1/webhooks=8192;/v1/comments/:id/reports=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := bodyLimits{
+		"/v1/comments":     2048,
+		"/v1/comments/:id": 4096,
+		"/v1/webhooks":     8192,
+	}
+	if !maps.Equal(limits, want) {
+		t.Errorf("got %v; want %v", limits, want)
+	}
+
+	for _, bad := range []string{"/v1/comments", "/v1/comments=big"} {
+		if err := limits.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded", bad)
+		}
+	}
+	if !maps.Equal(limits, want) {
+		t.Errorf("a failed Set changed the limits to %v", limits)
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := defaultConfig()
+	if cfg.port != 4000 || cfg.limiter.rps != 2 || cfg.limiter.burst != 5 || cfg.server.bodyLimits["/v1/comments"] != 4096 {
+		t.Errorf("got port %d, limiter %v/%d, body limits %v",
+			cfg.port, cfg.limiter.rps, cfg.limiter.burst, cfg.server.bodyLimits)
+	}
+
+	// Each call gets its own maps
+	cfg.cache.control["/v1/comments"] = "no-store"
+	if got := defaultConfig().cache.control["/v1/comments"]; got != "public, max-age=5" {
+		t.Errorf("changing one config's cache policies changed the defaults to %q", got)
+	}
+}
@@ -0,0 +1,86 @@
+// Filename: cmd/api/context.go
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is our own type for request context keys so that they
+// can't clash with keys set by other packages
+type contextKey string
+
+const (
+	clientIPContextKey  = contextKey("clientIP")
+	requestIDContextKey = contextKey("requestID")
+	bodyLimitContextKey = contextKey("bodyLimit")
+	routeContextKey     = contextKey("route")
+)
+
+// contextSetClientIP returns a copy of the request with the client IP
+// address added to its context
+func (a *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// contextGetClientIP reads the client IP address from the request
+// context. The second value is false if it was never set.
+func (a *application) contextGetClientIP(r *http.Request) (string, bool) {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	return ip, ok
+}
+
+// contextSetRequestID returns a copy of the request with the request ID
+// added to its context
+func (a *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID reads the request ID from the request context.
+// It returns an empty string if none was set.
+func (a *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// contextSetBodyLimit returns a copy of the request with the largest
+// body readJSON should accept for it
+func (a *application) contextSetBodyLimit(r *http.Request, limit int64) *http.Request {
+	ctx := context.WithValue(r.Context(), bodyLimitContextKey, limit)
+	return r.WithContext(ctx)
+}
+
+// contextGetBodyLimit reads the body limit for the request, falling back
+// to -max-body-bytes
+func (a *application) contextGetBodyLimit(r *http.Request) int64 {
+	limit, ok := r.Context().Value(bodyLimitContextKey).(int64)
+	if !ok {
+		return a.config.server.maxBodyBytes
+	}
+	return limit
+}
+
+// contextSetRoute returns a copy of the request with room for the
+// pattern of the route it matches. The router fills it in (see
+// matchedRoute) and middleware outside the router reads it back with
+// contextGetRoute once the request has been served.
+func (a *application) contextSetRoute(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(routeContextKey).(*string); ok {
+		return r
+	}
+	ctx := context.WithValue(r.Context(), routeContextKey, new(string))
+	return r.WithContext(ctx)
+}
+
+// contextGetRoute reads the pattern of the route that served the
+// request. It returns "unmatched" if no route did, e.g. for a 404 or a
+// request the rate limiter turned away.
+func (a *application) contextGetRoute(r *http.Request) string {
+	route, ok := r.Context().Value(routeContextKey).(*string)
+	if !ok || *route == "" {
+		return "unmatched"
+	}
+	return *route
+}
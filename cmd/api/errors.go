@@ -4,6 +4,10 @@ package main
 import (
   "fmt"
   "net/http"
+  "strconv"
+  "time"
+
+  "github.com/mickali02/qod/internal/data"
 )
 
 // log an error message
@@ -11,7 +15,12 @@ func (a *application)logError(r *http.Request, err error)  {
 
    method := r.Method
    uri := r.URL.RequestURI()
-   a.logger.Error(err.Error(), "method", method, "uri", uri)
+   ip, _ := a.contextGetClientIP(r)
+   a.logger.Error(err.Error(),
+      "request_id", a.contextGetRequestID(r),
+      "method", method,
+      "uri", uri,
+      "client_ip", ip)
     
 }
 
@@ -27,6 +36,20 @@ func (a *application)errorResponseJSON(w http.ResponseWriter,r *http.Request,sta
 
 // send an error response if our server messes up
 func (a *application)serverErrorResponse(w http.ResponseWriter, r *http.Request, err error)  {
+   // if the client has gone away there is nobody to send a response to
+   if r.Context().Err() != nil {
+      a.logger.Info("request cancelled by client",
+         "request_id", a.contextGetRequestID(r),
+         "method", r.Method,
+         "uri", r.URL.RequestURI())
+      return
+   }
+   // a query that ran out of time is a timeout, not a crash
+   if data.IsTimeout(err) {
+      a.logError(r, err)
+      a.timeoutResponse(w, r)
+      return
+   }
    // first thing is to log error message
    a.logError(r, err)
    // prepare a response to send to the client
@@ -35,6 +58,12 @@ func (a *application)serverErrorResponse(w http.ResponseWriter, r *http.Request,
 }
 
 
+// send an error response if the database took too long (504 - Gateway Timeout)
+func (a *application)timeoutResponse(w http.ResponseWriter, r *http.Request)  {
+   message := "the server took too long to process your request, please try again later"
+   a.errorResponseJSON(w, r, http.StatusGatewayTimeout, message)
+}
+
 // send an error response if our client messes up with a 404
 func (a *application)notFoundResponse(w http.ResponseWriter, r *http.Request)  {
    // we only log server errors, not client errors
@@ -64,7 +93,28 @@ func (a *application)failedValidationResponse(w http.ResponseWriter, r *http.Req
    }
 
 // send an error response if rate limit exceeded (429 - Too Many Requests)
-func (a *application)rateLimitExceededResponse(w http.ResponseWriter, r *http.Request)  {
+// retryAfter tells the client how long to wait before trying again
+func (a *application)rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration)  {
+   w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(retryAfter), 1)))
    message := "rate limit exceeded"
    a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
 }
+
+// send an error response if the request doesn't carry the admin token (401 - Unauthorized)
+func (a *application)invalidAdminTokenResponse(w http.ResponseWriter, r *http.Request)  {
+   w.Header().Set("WWW-Authenticate", "Bearer")
+   message := "invalid or missing admin token"
+   a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+// send an error response if the client has already reported a comment (409 - Conflict)
+func (a *application)duplicateReportResponse(w http.ResponseWriter, r *http.Request)  {
+   message := "you have already reported this comment"
+   a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// send an error response if the record changed since the client read it (409 - Conflict)
+func (a *application)editConflictResponse(w http.ResponseWriter, r *http.Request)  {
+   message := "unable to update the record due to an edit conflict, please try again"
+   a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
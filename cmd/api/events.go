@@ -0,0 +1,58 @@
+// Filename: cmd/api/events.go
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/mickali02/qod/internal/events"
+	"github.com/mickali02/qod/internal/jobs"
+)
+
+// startEventListener receives the comment changes made by every API
+// instance (including this one) and publishes them on a.events
+func (a *application) startEventListener() {
+	listener := &events.Listener{
+		DSN: a.config.db.dsn,
+		Bus: a.events,
+		OnError: func(err error) {
+			a.logger.Warn(err.Error())
+		},
+		OnReconnect: func() {
+			a.logger.Info("event listener reconnected, resyncing")
+		},
+	}
+
+	a.background("event listener", func(ctx context.Context) {
+		err := listener.Run(ctx)
+		if err != nil {
+			a.logger.Error("event listener stopped", "error", err)
+		}
+	})
+}
+
+// prunePeriodically runs a pruning job every interval. With the job
+// queue running it adds the job to the queue instead, so only one
+// instance prunes at a time.
+func (a *application) prunePeriodically(ctx context.Context, interval time.Duration, kind string, job jobs.Handler) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var err error
+		if a.jobs != nil {
+			err = a.jobs.Enqueue(ctx, kind, nil, jobs.EnqueueOptions{Key: kind})
+		} else {
+			err = job(ctx, jobs.Job{Kind: kind})
+		}
+		if err != nil && ctx.Err() == nil {
+			a.logger.Error("pruning", "kind", kind, "error", err)
+		}
+	}
+}
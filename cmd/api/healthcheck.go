@@ -2,30 +2,152 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"time"
 )
 
 const version = "1.0.0"
 
-func (a *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
-	// Create a map to hold healthcheck data.
-	//panic("Apples & Oranges") //deliberate panic
+// readinessTimeout is how long all the readiness checks together may take
+const readinessTimeout = 2 * time.Second
+
+// healthChecker reports whether one dependency is usable. It returns
+// nil when it is.
+type healthChecker func(ctx context.Context) error
+
+// healthChecks holds the checkers run by the readiness endpoint
+type healthChecks struct {
+	mu       sync.RWMutex
+	names    []string
+	checkers map[string]healthChecker
+}
+
+// registerHealthCheck adds a checker to the readiness endpoint
+func (a *application) registerHealthCheck(name string, check healthChecker) {
+	a.health.mu.Lock()
+	defer a.health.mu.Unlock()
+
+	if a.health.checkers == nil {
+		a.health.checkers = make(map[string]healthChecker)
+	}
+	if _, exists := a.health.checkers[name]; !exists {
+		a.health.names = append(a.health.names, name)
+	}
+	a.health.checkers[name] = check
+}
+
+// liveHandler tells the caller that the process is up. It doesn't look
+// at any dependencies, so a database outage won't get us restarted.
+func (a *application) liveHandler(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
-		"status":      "available",
+		"status": "available",
 		"system_info": map[string]string{
-		"environment": a.config.env,
-		"version":     version, 
+			"environment": a.config.env,
+			"version":     version,
 		},
+		"uptime": time.Since(a.startedAt).Round(time.Second).String(),
 	}
 
 	err := a.writeJSON(w, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
-		a.logger.Error(err.Error())
-		http.Error(w, "The server encountered a problem and could not process your request", http.StatusInternalServerError)
 	}
+}
+
+// readyHandler tells the caller whether we can serve traffic: every
+// registered checker must pass and we must not be shutting down.
+func (a *application) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	status := "ready"
+	httpStatus := http.StatusOK
+
+	a.health.mu.RLock()
+	names := append([]string(nil), a.health.names...)
+	checkers := make(map[string]healthChecker, len(a.health.checkers))
+	for name, check := range a.health.checkers {
+		checkers[name] = check
+	}
+	a.health.mu.RUnlock()
+
+	// Run the checks at the same time so one slow dependency doesn't
+	// eat the whole timeout for the others
+	type checkResult struct {
+		Status   string  `json:"status"`
+		Error    string  `json:"error,omitempty"`
+		Duration float64 `json:"duration_ms"`
+	}
+	results := make([]checkResult, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := checkers[name](ctx)
+			results[i] = checkResult{Status: "ok", Duration: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Status = "failed"
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
 
+	checks := make(map[string]checkResult, len(names))
+	for i, name := range names {
+		checks[name] = results[i]
+		if results[i].Status != "ok" {
+			status = "unavailable"
+			httpStatus = http.StatusServiceUnavailable
+		}
+	}
+
+	if a.draining.Load() {
+		status = "draining"
+		httpStatus = http.StatusServiceUnavailable
+	}
 
-	w.Header().Set("Content-Type", "application/json")
+	stats := a.db.Stats()
+	database := map[string]any{
+		"pool": map[string]any{
+			"max_open_connections": stats.MaxOpenConnections,
+			"open_connections":     stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
+			"wait_count":           stats.WaitCount,
+			"wait_duration":        stats.WaitDuration.String(),
+		},
+	}
+	if a.replicas != nil {
+		// A replica being down doesn't make us unready: reads fall back
+		// to the primary
+		database["replicas"] = a.replicas.Status()
+	}
+	migrationVersion, dirty, err := a.migrator.Version(ctx)
+	if err == nil {
+		database["migration_version"] = migrationVersion
+		database["migration_latest"] = a.migrator.Latest()
+		database["migration_dirty"] = dirty
+	}
 
-}
\ No newline at end of file
+	data := envelope{
+		"status": status,
+		"checks": checks,
+		"database": database,
+		"system_info": map[string]string{
+			"environment": a.config.env,
+			"version":     version,
+		},
+		"uptime": time.Since(a.startedAt).Round(time.Second).String(),
+	}
+
+	err = a.writeJSON(w, httpStatus, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
     "net/url"
     "github.com/julienschmidt/httprouter"
+    "github.com/mickali02/qod/internal/tracing"
     "github.com/mickali02/qod/internal/validator"
 )
 
@@ -40,9 +41,14 @@ func (a *application) writeJSON(w http.ResponseWriter, status int, data envelope
 }
 
 func (a *application) readJSON(w http.ResponseWriter, r *http.Request, destination any) error {
-    // Use http.MaxBytesReader to limit the size of the request body to 250KB.
-    maxBytes := 256_000
-    r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+    // Time the decoding separately so slow request bodies show up in traces
+    _, span := tracing.Start(r.Context(), "readJSON")
+    defer span.End()
+
+    // Use http.MaxBytesReader to limit the size of the request body. The
+    // limit is -max-body-bytes unless the route has its own (-body-limits).
+    maxBytes := a.contextGetBodyLimit(r)
+    r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 
     // Initialize the JSON decoder and call the DisallowUnknownFields() method on it
     // before decoding. This means that if the JSON from the client includes any
@@ -95,7 +101,7 @@ func (a *application) readJSON(w http.ResponseWriter, r *http.Request, destinati
 
         // Use the errors.As() function to check whether the error has the type
         // *http.MaxBytesError. If it does, then it means the request body exceeded our
-        // size limit of 250KB and we return a clear error message.
+        // size limit and we return a clear error message.
         case errors.As(err, &maxBytesError):
              return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
 
@@ -173,4 +179,15 @@ func (a *application)getSingleIntegerParameter( queryParameters url.Values,key s
    }
 
    return intValue
-}
\ No newline at end of file
+}
+
+// clientIP returns the IP address of the client making the request.
+// The realIP middleware normally resolves it once and stores it in the
+// request context.
+func (a *application) clientIP(r *http.Request) (string, error) {
+	ip, ok := a.contextGetClientIP(r)
+	if ok {
+		return ip, nil
+	}
+	return a.resolveClientIP(r)
+}
@@ -0,0 +1,70 @@
+// Filename: cmd/api/jobs.go
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/mickali02/qod/internal/jobs"
+)
+
+// Job kinds
+const (
+	jobPruneEvents        = "comment_events.prune"
+	jobPruneWebhookOutbox = "webhook_outbox.prune"
+)
+
+// startJobs registers our jobs and starts the workers. serve() stops
+// them when the server shuts down.
+func (a *application) startJobs() {
+	runner := jobs.NewRunner(a.db, a.config.jobs.workers, a.config.jobs.pollInterval)
+	runner.OnError = func(err error) {
+		a.logger.Error("job queue", "error", err)
+	}
+	runner.OnResult = func(result jobs.Result) {
+		a.metrics.jobs.Inc(result.Job.Kind, result.Outcome)
+
+		attrs := []any{"job_id", result.Job.ID, "kind", result.Job.Kind,
+			"attempt", result.Job.Attempt, "duration", result.Duration}
+		switch result.Outcome {
+		case "succeeded":
+			a.logger.Debug("job succeeded", attrs...)
+		case "retrying":
+			a.logger.Warn("job failed, will retry", append(attrs, "error", result.Err)...)
+		case "dead":
+			a.logger.Error("job failed for the last time", append(attrs, "error", result.Err)...)
+		}
+	}
+
+	runner.Register(jobPruneEvents, a.pruneEventsJob, jobs.Options{Timeout: 5 * time.Minute})
+	runner.Register(jobPruneWebhookOutbox, a.pruneWebhookOutboxJob, jobs.Options{Timeout: 5 * time.Minute})
+
+	runner.Start()
+	a.jobs = runner
+}
+
+// pruneEventsJob trims the comment_events log so it only covers
+// -stream-retention
+func (a *application) pruneEventsJob(ctx context.Context, job jobs.Job) error {
+	deleted, err := a.eventModel.Prune(ctx, a.config.stream.retention)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		a.logger.Info("pruned comment events", "deleted", deleted)
+	}
+	return nil
+}
+
+// pruneWebhookOutboxJob deletes sent webhook events, and their delivery
+// log, once they are older than -webhooks-retention
+func (a *application) pruneWebhookOutboxJob(ctx context.Context, job jobs.Job) error {
+	deleted, err := a.webhookModel.Prune(ctx, a.config.webhooks.retention)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		a.logger.Info("pruned webhook events", "deleted", deleted)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Filename: cmd/api/limiter.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mickali02/qod/internal/limiter"
+)
+
+// newLimiter creates a rate limiter using the backend chosen with the
+// -limiter-backend flag. The name keeps the keys of different limiters
+// apart when they share a store.
+func (a *application) newLimiter(name string, rps float64, burst int) (limiter.Limiter, error) {
+	var l limiter.Limiter
+	switch a.config.limiter.backend {
+	case "memory":
+		l = limiter.NewMemory(rps, burst)
+	case "postgres":
+		pg := limiter.NewPostgres(a.db, name, rps, burst)
+		pg.QueryTimeout = a.config.db.queryTimeout
+		pg.OnError = func(err error) {
+			a.logger.Error("rate limiter cleanup", "limiter", name, "error", err)
+		}
+		l = pg
+	default:
+		return nil, fmt.Errorf("unknown limiter backend %q", a.config.limiter.backend)
+	}
+
+	if janitor, ok := l.(limiter.Janitor); ok {
+		a.background(name+" limiter janitor", janitor.RunJanitor)
+	}
+	return l, nil
+}
+
+// setupLimiters creates the read, write and author limiters
+func (a *application) setupLimiters() error {
+	var err error
+
+	a.limiters.read, err = a.newLimiter("read", a.config.limiter.rps, a.config.limiter.burst)
+	if err != nil {
+		return err
+	}
+	a.limiters.write, err = a.newLimiter("write", a.config.limiter.write.rps, a.config.limiter.write.burst)
+	if err != nil {
+		return err
+	}
+	perMinute := a.config.limiter.authorPerMinute
+	a.limiters.author, err = a.newLimiter("author", float64(perMinute)/60, perMinute)
+	return err
+}
+
+// setRateLimitHeaders tells the client about its current budget
+func setRateLimitHeaders(w http.ResponseWriter, result limiter.Result) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,66 @@
+// Filename: cmd/api/limiter_test.go
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mickali02/qod/internal/limiter"
+)
+
+func TestCeilSeconds(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{time.Minute, 60},
+	}
+	for _, tt := range tests {
+		if got := ceilSeconds(tt.d); got != tt.want {
+			t.Errorf("ceilSeconds(%v) = %d; want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.limiters.read = limiter.NewMemory(0.5, 2)
+	app.limiters.write = limiter.NewMemory(0.5, 1)
+
+	tests := []struct {
+		method        string
+		target        string
+		wantStatus    int
+		wantRemaining string
+		wantReset     string
+		wantRetry     string
+	}{
+		{http.MethodGet, "/v1/comments", http.StatusOK, "1", "2", ""},
+		{http.MethodGet, "/v1/comments", http.StatusOK, "0", "2", ""},
+		{http.MethodGet, "/v1/comments", http.StatusTooManyRequests, "0", "2", "2"},
+		// Writes have their own budget
+		{http.MethodDelete, "/v1/comments/1", http.StatusNotFound, "0", "2", ""},
+		{http.MethodDelete, "/v1/comments/1", http.StatusTooManyRequests, "0", "2", "2"},
+	}
+	for i, tt := range tests {
+		res := app.do(t, tt.method, tt.target, nil)
+		if res.status != tt.wantStatus {
+			t.Fatalf("request %d: status = %d; want %d (%s)", i, res.status, tt.wantStatus, res.body)
+		}
+		for header, want := range map[string]string{
+			"X-RateLimit-Remaining": tt.wantRemaining,
+			"X-RateLimit-Reset":     tt.wantReset,
+			"Retry-After":           tt.wantRetry,
+		} {
+			if got := res.header.Get(header); got != want {
+				t.Errorf("request %d: %s = %q; want %q", i, header, got, want)
+			}
+		}
+	}
+}
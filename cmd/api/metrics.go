@@ -0,0 +1,107 @@
+// Filename: cmd/api/metrics.go
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/mickali02/qod/internal/metrics"
+)
+
+// appMetrics holds everything we count about the running application
+type appMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	inFlight        *metrics.Gauge
+	rateLimited     *metrics.CounterVec
+	panics          *metrics.CounterVec
+	cache           *metrics.CounterVec
+	streamClients   *metrics.Gauge
+	wsClients       *metrics.Gauge
+	webhooks        *metrics.CounterVec
+	jobs            *metrics.CounterVec
+}
+
+// newAppMetrics creates our metrics, including gauges that read the
+// database pool statistics each time they are scraped
+func (a *application) newAppMetrics() *appMetrics {
+	m := &appMetrics{
+		registry: metrics.NewRegistry(),
+		requests: metrics.NewCounterVec("qod_http_requests_total",
+			"Total HTTP requests by route, method and status.", "route", "method", "status"),
+		requestDuration: metrics.NewHistogramVec("qod_http_request_duration_seconds",
+			"HTTP request latency by route and method.", metrics.DefaultBuckets, "route", "method"),
+		inFlight: metrics.NewGauge("qod_http_requests_in_flight",
+			"HTTP requests currently being served."),
+		rateLimited: metrics.NewCounterVec("qod_rate_limited_requests_total",
+			"Requests rejected by the rate limiter by limiter class.", "class"),
+		panics: metrics.NewCounterVec("qod_panics_recovered_total",
+			"Panics caught by the recoverPanic middleware."),
+		cache: metrics.NewCounterVec("qod_cache_lookups_total",
+			"Comment cache lookups by kind (get|list) and result (hit|miss).", "kind", "result"),
+		streamClients: metrics.NewGauge("qod_stream_clients",
+			"Clients connected to the comment feed."),
+		wsClients: metrics.NewGauge("qod_websocket_clients",
+			"Clients connected over WebSocket."),
+		webhooks: metrics.NewCounterVec("qod_webhook_attempts_total",
+			"Webhook delivery attempts by result (succeeded|retrying|failed).", "result"),
+		jobs: metrics.NewCounterVec("qod_job_attempts_total",
+			"Background job attempts by kind and outcome (succeeded|retrying|dead).", "kind", "outcome"),
+	}
+
+	m.registry.Register(m.requests, m.requestDuration, m.inFlight, m.rateLimited, m.panics, m.cache, m.streamClients, m.wsClients, m.webhooks, m.jobs)
+
+	m.registry.Register(
+		metrics.NewGaugeFunc("qod_db_max_open_connections", "Maximum number of open connections to the database.",
+			func() float64 { return float64(a.db.Stats().MaxOpenConnections) }),
+		metrics.NewGaugeFunc("qod_db_open_connections", "Established connections, both in use and idle.",
+			func() float64 { return float64(a.db.Stats().OpenConnections) }),
+		metrics.NewGaugeFunc("qod_db_in_use_connections", "Connections currently in use.",
+			func() float64 { return float64(a.db.Stats().InUse) }),
+		metrics.NewGaugeFunc("qod_db_idle_connections", "Idle connections.",
+			func() float64 { return float64(a.db.Stats().Idle) }),
+		metrics.NewCounterFunc("qod_db_wait_count_total", "Connections waited for.",
+			func() float64 { return float64(a.db.Stats().WaitCount) }),
+		metrics.NewCounterFunc("qod_db_wait_duration_seconds_total", "Time spent waiting for connections.",
+			func() float64 { return a.db.Stats().WaitDuration.Seconds() }),
+		metrics.NewCounterFunc("qod_db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.",
+			func() float64 { return float64(a.db.Stats().MaxIdleClosed) }),
+		metrics.NewCounterFunc("qod_db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.",
+			func() float64 { return float64(a.db.Stats().MaxIdleTimeClosed) }),
+		metrics.NewCounterFunc("qod_db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.",
+			func() float64 { return float64(a.db.Stats().MaxLifetimeClosed) }),
+		metrics.NewGaugeFunc("qod_goroutines", "Number of goroutines that currently exist.",
+			func() float64 { return float64(runtime.NumGoroutine()) }),
+	)
+
+	return m
+}
+
+// metricsHandler exposes the metrics in the Prometheus text format
+func (a *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	a.metrics.registry.WriteText(w)
+}
+
+// recordMetrics counts every request and how long it took. Requests are
+// grouped by their route pattern (/v1/comments/:id) rather than the raw
+// path so that each comment doesn't get its own series.
+func (a *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := newResponseWriter(w)
+		r = a.contextSetRoute(r)
+
+		a.metrics.inFlight.Inc()
+		defer a.metrics.inFlight.Dec()
+
+		next.ServeHTTP(rw, r)
+
+		route := a.contextGetRoute(r)
+		a.metrics.requests.Inc(route, r.Method, strconv.Itoa(rw.status))
+		a.metrics.requestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
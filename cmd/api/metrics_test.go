@@ -0,0 +1,45 @@
+// Filename: cmd/api/metrics_test.go
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mickali02/qod/internal/data"
+)
+
+func TestRequestMetricsUseRoutePatterns(t *testing.T) {
+	app := newTestApplication(t)
+	app.addComment(t, "hello", "Mickali", data.StatusPublished)
+
+	app.do(t, http.MethodGet, "/v1/comments/1", nil)
+	// A path segment that happens to equal a parameter value must not be
+	// mistaken for the parameter
+	app.do(t, http.MethodGet, "/v1/comments/comments", nil)
+	app.do(t, http.MethodGet, "/v1/comments/1/missing", nil)
+	app.do(t, http.MethodPut, "/v1/comments/1", nil)
+
+	res := app.do(t, http.MethodGet, "/v1/metrics", nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d (%s)", res.status, res.body)
+	}
+	body := string(res.body)
+	if strings.Contains(body, `"error"`) {
+		t.Fatalf("metrics output contains an error:\n%s", body)
+	}
+
+	for _, want := range []string{
+		`qod_http_requests_total{route="/v1/comments/:id",method="GET",status="200"} 1`,
+		`qod_http_requests_total{route="/v1/comments/:id",method="GET",status="404"} 1`,
+		`qod_http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`qod_http_requests_total{route="unmatched",method="PUT",status="405"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing\n%s\ngot:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `route="/v1/:id`) || strings.Contains(body, `route="/v1/comments/1"`) {
+		t.Errorf("metrics have a route built from the path:\n%s", body)
+	}
+}
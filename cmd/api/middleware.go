@@ -1,14 +1,24 @@
 package main
 
 import (
-	"net"
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
-	"golang.org/x/time/rate"
+
+	"github.com/mickali02/qod/internal/data"
+	"github.com/mickali02/qod/internal/tracing"
 )
 
+// primaryCookie tells us a client wrote something recently, so its reads
+// should come from the primary rather than a replica that may lag
+const primaryCookie = "qod_primary"
+
 func (a *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// defer will be called when the stack unwinds
@@ -16,6 +26,7 @@ func (a *application) recoverPanic(next http.Handler) http.Handler {
 			// recover() checks for panics
 			err := recover()
 			if err != nil {
+				a.metrics.panics.Inc()
 				w.Header().Set("Connection", "close")
 				a.serverErrorResponse(w, r, fmt.Errorf("%s", err))
 			}
@@ -24,6 +35,108 @@ func (a *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// requestID gives every request an ID. If the caller (or a proxy in
+// front of us) already sent an X-Request-ID we keep it so the request
+// can be followed across services.
+func (a *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = a.contextSetRequestID(r, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logRequest writes one access log entry for every request
+func (a *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := newResponseWriter(w)
+
+		next.ServeHTTP(rw, r)
+
+		traceID := ""
+		if span := tracing.SpanFromContext(r.Context()); span != nil {
+			traceID = span.SpanContext().TraceID.String()
+		}
+
+		ip, _ := a.contextGetClientIP(r)
+		a.logger.Info("request",
+			"request_id", a.contextGetRequestID(r),
+			"trace_id", traceID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.status,
+			"bytes", rw.bytes,
+			"duration", time.Since(start),
+			"client_ip", ip,
+		)
+	})
+}
+
+// realIP works out the client's IP address (looking through our trusted
+// proxies) and stores it in the request context for later handlers
+func (a *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := a.resolveClientIP(r)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		r = a.contextSetClientIP(r, ip)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyLimit gives route the body limit configured for it with
+// -body-limits. readJSON reads it from the request context.
+func (a *application) bodyLimit(route string, next http.HandlerFunc) http.HandlerFunc {
+	limit, ok := a.config.server.bodyLimits[route]
+	if !ok {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, a.contextSetBodyLimit(r, limit))
+	}
+}
+
+// matchedRoute notes which route pattern (/v1/comments/:id) is serving
+// the request, so requests are grouped by route rather than by path and
+// each comment doesn't get its own metrics series
+func (a *application) matchedRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeContextKey).(*string); ok {
+			*route = pattern
+		}
+		next(w, r)
+	}
+}
+
+// strictTransportSecurity tells browsers to only use HTTPS for this host
+// from now on. It is only sent in production, so a developer's browser
+// doesn't get stuck on HTTPS for localhost.
+func (a *application) strictTransportSecurity(next http.Handler) http.Handler {
+	maxAge := int(a.config.tls.hstsMaxAge.Seconds())
+	if a.config.env != "production" || !a.config.tlsEnabled() || maxAge == 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", maxAge)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers ignore it over plain HTTP anyway
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (a *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
@@ -56,63 +169,161 @@ func (a *application) enableCORS(next http.Handler) http.Handler {
 }
 
 func (a *application) rateLimit(next http.Handler) http.Handler {
-	// Define a client struct to hold the rate limiter and last seen time for each client.
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	// A background goroutine to remove old entries from the clients map.
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
-		}
-	}()
-
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if a.config.limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			ip, err := a.clientIP(r)
 			if err != nil {
 				a.serverErrorResponse(w, r, err)
 				return
 			}
 
-			mu.Lock()
-
-			_, found := clients[ip]
-			// Check if the IP address is already in the map.
-			if  !found {
-				// If it's not found, create a new limiter for this IP.
-				clients[ip] = &client{limiter: rate.NewLimiter(
-											   rate.Limit(a.config.limiter.rps), 
-											   a.config.limiter.burst)}
-			} 
-			// Update the last seen time for the client on every request.
-			clients[ip].lastSeen = time.Now()
-
-			// Check if the request is allowed.
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				a.rateLimitExceededResponse(w, r)
-				return
+			// Reads and writes have their own budgets so that a client
+			// browsing comments doesn't use up its posting allowance.
+			class, store := "read", a.limiters.read
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				class, store = "write", a.limiters.write
+			}
+
+			ctx, span := tracing.Start(r.Context(), "rateLimit")
+			span.SetAttribute("limiter.class", class)
+			result, err := store.Allow(ctx, ip)
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				// Don't take the whole API down because the limiter
+				// store is unavailable; log it and let the request in.
+				a.logError(r, err)
+			} else {
+				setRateLimitHeaders(w, result)
+
+				if !result.Allowed {
+					a.metrics.rateLimited.Inc(class)
+					a.rateLimitExceededResponse(w, r, result.RetryAfter)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin only lets requests through which carry the admin token
+// (-admin-token) as "Authorization: Bearer <token>". Without a token
+// configured nobody gets through.
+func (a *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if a.config.admin.token == "" || !strings.EqualFold(scheme, "Bearer") ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(a.config.admin.token)) != 1 {
+			a.invalidAdminTokenResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// readYourWrites sends a request's reads to the primary database when it
+// is a write (an update reads the comment before changing it) or when the
+// client wrote something in the last few seconds
+func (a *application) readYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.replicas == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if _, err := r.Cookie(primaryCookie); err == nil {
+				r = r.WithContext(data.WithPrimary(r.Context()))
+			}
+		default:
+			r = r.WithContext(data.WithPrimary(r.Context()))
+			if a.config.db.replica.stickiness > 0 {
+				http.SetCookie(w, &http.Cookie{
+					Name:     primaryCookie,
+					Value:    "1",
+					Path:     "/",
+					MaxAge:   ceilSeconds(a.config.db.replica.stickiness),
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
 			}
-            
-            // IMPORTANT: Unlock the mutex.
-			mu.Unlock()
 		}
 
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// newRequestID creates a random 128-bit request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID checks that an incoming request ID is safe to echo back
+// and to write to our logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// responseWriter wraps http.ResponseWriter so that middleware can see
+// the status code and how many bytes were sent
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the original writer (for
+// flushing, deadlines, etc.)
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack lets a WebSocket take over the connection. The handshake
+// response is written straight to the connection, so record it here.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.status = http.StatusSwitchingProtocols
+		rw.wroteHeader = true
+	}
+	return conn, buf, err
+}
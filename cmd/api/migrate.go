@@ -0,0 +1,77 @@
+// Filename: cmd/api/migrate.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mickali02/qod/internal/migrate"
+)
+
+// runMigrateCommand carries out the -migrate flag: up, down (one step),
+// status, or to=N
+func runMigrateCommand(ctx context.Context, migrator *migrate.Migrator, command string, out io.Writer) error {
+	switch {
+	case command == "up":
+		return migrator.Up(ctx)
+	case command == "down":
+		return migrator.Down(ctx)
+	case command == "status":
+		return printMigrationStatus(ctx, migrator, out)
+	case strings.HasPrefix(command, "to="):
+		target, err := strconv.ParseInt(strings.TrimPrefix(command, "to="), 10, 64)
+		if err != nil || target < 0 {
+			return fmt.Errorf("invalid migration version in %q", command)
+		}
+		return migrator.To(ctx, target)
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up|down|status|to=N)", command)
+	}
+}
+
+// printMigrationStatus lists every migration and whether it is applied
+func printMigrationStatus(ctx context.Context, migrator *migrate.Migrator, out io.Writer) error {
+	current, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "current version: %d (latest %d)", current, migrator.Latest())
+	if dirty {
+		fmt.Fprint(out, " DIRTY")
+	}
+	fmt.Fprintln(out)
+
+	for _, status := range statuses {
+		mark := " "
+		if status.Applied {
+			mark = "x"
+		}
+		fmt.Fprintf(out, "[%s] %06d %s\n", mark, status.Version, status.Name)
+	}
+	return nil
+}
+
+// checkSchema returns an error if the database is behind (or ahead of)
+// the migrations built into this binary, or was left dirty
+func checkSchema(ctx context.Context, migrator *migrate.Migrator) error {
+	current, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("database schema version %d is dirty", current)
+	}
+	if current != migrator.Latest() {
+		return fmt.Errorf("database schema is at version %d but this build expects %d; run with -migrate=up",
+			current, migrator.Latest())
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Filename: cmd/api/moderation.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mickali02/qod/internal/data"
+	"github.com/mickali02/qod/internal/validator"
+)
+
+// listModerationQueueHandler lists comments by moderation status.
+// By default it shows the comments waiting for review.
+func (a *application) listModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var queryParametersData struct {
+		Content string
+		Author  string
+		Status  string
+		data.Filters
+	}
+
+	queryParameters := r.URL.Query()
+
+	queryParametersData.Content = a.getSingleQueryParameter(queryParameters, "content", "")
+	queryParametersData.Author = a.getSingleQueryParameter(queryParameters, "author", "")
+	queryParametersData.Status = a.getSingleQueryParameter(queryParameters, "status", data.StatusPending)
+
+	v := validator.New()
+
+	queryParametersData.Filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
+	queryParametersData.Filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "id")
+	queryParametersData.Filters.SortSafeList = []string{"id", "author", "-id", "-author"}
+
+	data.ValidateFilters(v, queryParametersData.Filters)
+	v.Check(validator.PermittedValue(queryParametersData.Status, data.StatusPending, data.StatusRejected, data.StatusPublished), "status", "invalid status value")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := a.commentModel.GetAll(r.Context(), queryParametersData.Content, queryParametersData.Author, queryParametersData.Status, queryParametersData.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"comments": comments, "@metadata": metadata}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// approveCommentHandler publishes a comment from the moderation queue
+func (a *application) approveCommentHandler(w http.ResponseWriter, r *http.Request) {
+	a.setCommentStatus(w, r, data.StatusPublished)
+}
+
+// rejectCommentHandler removes a comment from public view
+func (a *application) rejectCommentHandler(w http.ResponseWriter, r *http.Request) {
+	a.setCommentStatus(w, r, data.StatusRejected)
+}
+
+// setCommentStatus does the work for the approve and reject handlers
+func (a *application) setCommentStatus(w http.ResponseWriter, r *http.Request, status string) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.commentModel.UpdateStatus(r.Context(), id, status)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The reports that sent it here have been dealt with; only new ones
+	// count towards hiding it again
+	err = a.reportModel.Resolve(r.Context(), id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	comment, err := a.commentModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	response := envelope{"comment": comment}
+	err = a.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,79 @@
+// Filename: cmd/api/moderation_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mickali02/qod/internal/data"
+)
+
+const testAdminToken = "correct-horse-battery-staple"
+
+func TestModerationRoutesNeedAdminToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		configured    string // the -admin-token setting
+		authorization string
+		wantStatus    int
+	}{
+		{"no token configured", "", "Bearer ", http.StatusUnauthorized},
+		{"no token sent", testAdminToken, "", http.StatusUnauthorized},
+		{"wrong token", testAdminToken, "Bearer correct-horse-battery-stapler", http.StatusUnauthorized},
+		{"wrong scheme", testAdminToken, "Basic " + testAdminToken, http.StatusUnauthorized},
+		{"right token", testAdminToken, "Bearer " + testAdminToken, http.StatusOK},
+		{"scheme in lower case", testAdminToken, "bearer " + testAdminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.admin.token = tt.configured
+			app.addComment(t, "Waiting", "Mickali", data.StatusPending)
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/moderation/comments", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			res := app.send(t, r)
+			if res.status != tt.wantStatus {
+				t.Fatalf("status = %d; want %d (%s)", res.status, tt.wantStatus, res.body)
+			}
+			if res.status == http.StatusUnauthorized && res.header.Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q", res.header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.admin.token = testAdminToken
+
+	for _, route := range []struct{ method, target string }{
+		{http.MethodGet, "/v1/moderation/comments"},
+		{http.MethodPost, "/v1/moderation/comments/99/approve"},
+		{http.MethodPost, "/v1/moderation/comments/99/reject"},
+		{http.MethodGet, "/v1/reports?comment_id=-1"},
+		{http.MethodPost, "/v1/webhooks"},
+		{http.MethodGet, "/v1/webhooks?page=0"},
+		{http.MethodGet, "/v1/webhooks/0"},
+		{http.MethodPatch, "/v1/webhooks/0"},
+		{http.MethodDelete, "/v1/webhooks/0"},
+		{http.MethodGet, "/v1/webhooks/0/deliveries"},
+	} {
+		res := app.do(t, route.method, route.target, nil)
+		if res.status != http.StatusUnauthorized {
+			t.Errorf("%s %s without the token: status = %d; want 401", route.method, route.target, res.status)
+		}
+
+		// The token gets the request as far as the handler
+		r := httptest.NewRequest(route.method, route.target, nil)
+		r.Header.Set("Authorization", "Bearer "+testAdminToken)
+		res = app.send(t, r)
+		if res.status == http.StatusUnauthorized {
+			t.Errorf("%s %s with the token: status = %d (%s)", route.method, route.target, res.status, res.body)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Filename: cmd/api/realip.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies turns a space separated list of CIDR ranges (or
+// single IP addresses) into networks
+func parseTrustedProxies(val string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, field := range strings.Fields(val) {
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", field)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", field)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// isTrustedProxy reports whether ip belongs to one of our proxies
+func (a *application) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range a.config.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP works out the address of the real client. The
+// forwarding headers are only believed when the request came from one
+// of our trusted proxies, otherwise anybody could pick their own IP.
+func (a *application) resolveClientIP(r *http.Request) (string, error) {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	if !a.isTrustedProxy(remote) {
+		return remote, nil
+	}
+
+	// Build the chain of hops the request went through, client first.
+	var hops []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		hops = parseForwarded(forwarded)
+	} else if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, value := range xff {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, cleanHost(hop))
+			}
+		}
+	} else if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		hops = []string{cleanHost(realIP)}
+	}
+
+	// Walk back from the proxy nearest to us. The first address which
+	// isn't one of our proxies is the client.
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		if !a.isTrustedProxy(hops[i]) || i == 0 {
+			return hops[i], nil
+		}
+	}
+
+	return remote, nil
+}
+
+// parseForwarded pulls the "for" addresses out of RFC 7239 Forwarded
+// headers, e.g. Forwarded: for=192.0.2.60;proto=http, for="[2001:db8::17]:4711"
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hops = append(hops, cleanHost(val))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// cleanHost strips quotes, IPv6 brackets and any port from an address
+func cleanHost(host string) string {
+	host = strings.Trim(strings.TrimSpace(host), `"`)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
@@ -0,0 +1,146 @@
+// Filename: cmd/api/reports.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mickali02/qod/internal/data"
+	"github.com/mickali02/qod/internal/validator"
+)
+
+// createReportHandler lets a reader flag a comment. Once a comment has
+// been reported enough times it is hidden and sent back to moderation.
+func (a *application) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	comment, err := a.commentModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if comment.Status != data.StatusPublished {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	reporter, err := a.clientIP(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	report := &data.Report{
+		CommentID: comment.ID,
+		Reason:    incomingData.Reason,
+		Details:   incomingData.Details,
+		Reporter:  reporter,
+	}
+
+	v := validator.New()
+	data.ValidateReport(v, report)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.reportModel.Insert(r.Context(), report)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReport):
+			a.duplicateReportResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Hide the comment once it crosses the threshold (0 turns this off)
+	threshold := a.config.reports.hideThreshold
+	if threshold > 0 {
+		count, err := a.reportModel.CountForComment(r.Context(), comment.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if count >= threshold {
+			err = a.commentModel.UpdateStatus(r.Context(), comment.ID, data.StatusPending)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+			a.logger.Info("comment hidden after reports", "comment_id", comment.ID, "reports", count)
+		}
+	}
+
+	response := envelope{"report": report}
+	err = a.writeJSON(w, http.StatusCreated, response, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// listReportsHandler lets moderators page through reports
+func (a *application) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	var queryParametersData struct {
+		CommentID int
+		Reason    string
+		data.Filters
+	}
+
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+
+	queryParametersData.CommentID = a.getSingleIntegerParameter(queryParameters, "comment_id", 0, v)
+	queryParametersData.Reason = a.getSingleQueryParameter(queryParameters, "reason", "")
+
+	queryParametersData.Filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
+	queryParametersData.Filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "id")
+	queryParametersData.Filters.SortSafeList = []string{"id", "created_at", "comment_id", "-id", "-created_at", "-comment_id"}
+
+	data.ValidateFilters(v, queryParametersData.Filters)
+	v.Check(queryParametersData.CommentID >= 0, "comment_id", "must not be negative")
+	if queryParametersData.Reason != "" {
+		v.Check(validator.PermittedValue(queryParametersData.Reason, data.ReportReasons...), "reason", "invalid reason value")
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reports, metadata, err := a.reportModel.GetAll(r.Context(), int64(queryParametersData.CommentID), queryParametersData.Reason, queryParametersData.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"reports": reports, "@metadata": metadata}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -17,15 +17,93 @@ func (a *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(a.notFoundResponse)
 	// handle 405
 	router.MethodNotAllowed = http.HandlerFunc(a.methodNotAllowedResponse)
+
+	// route wraps a handler in everything a route gets: its own tracing
+	// span (named after the handler, so it is traced before anything
+	// else wraps it), its Cache-Control policy and body limit
+	route := func(method string, path string, handler http.HandlerFunc) http.HandlerFunc {
+		handler = a.traceHandler(handler)
+		if method == http.MethodGet {
+			handler = a.cacheControl(path, handler)
+		}
+		return a.bodyLimit(path, handler)
+	}
+	// handle registers a route with the router, noting its pattern for
+	// the metrics and tracing middleware
+	handle := func(method string, path string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, path, a.matchedRoute(path, route(method, path, handler)))
+	}
+	// handleAdmin registers a route only the admin token opens
+	handleAdmin := func(method string, path string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, path, a.matchedRoute(path, a.requireAdmin(route(method, path, handler))))
+	}
+
 	// setup routes
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", a.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/comments", a.createCommentHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/comments", a.listCommentsHandler)
+	handle(http.MethodGet, "/v1/healthcheck", a.liveHandler)
+	handle(http.MethodGet, "/v1/healthcheck/live", a.liveHandler)
+	handle(http.MethodGet, "/v1/healthcheck/ready", a.readyHandler)
+	handle(http.MethodGet, "/v1/metrics", a.metricsHandler)
+	handle(http.MethodPost, "/v1/comments", a.createCommentHandler)
+	handle(http.MethodGet, "/v1/comments", a.listCommentsHandler)
+
+	handle(http.MethodGet, "/v1/comments/:id", a.displayCommentHandler)
+	handle(http.MethodPatch, "/v1/comments/:id", a.updateCommentHandler)
+	handle(http.MethodDelete, "/v1/comments/:id", a.deleteCommentHandler)
+
+	handle(http.MethodPost, "/v1/comments/:id/reports", a.createReportHandler)
+
+	// live updates (the comment stream is served in front of the router;
+	// see commentStreamPath)
+	stream := a.matchedRoute(commentStreamPath, route(http.MethodGet, commentStreamPath, a.streamCommentsHandler))
+	handle(http.MethodGet, "/v1/ws", a.websocketHandler)
+
+	// moderation routes
+	handleAdmin(http.MethodGet, "/v1/moderation/comments", a.listModerationQueueHandler)
+	handleAdmin(http.MethodPost, "/v1/moderation/comments/:id/approve", a.approveCommentHandler)
+	handleAdmin(http.MethodPost, "/v1/moderation/comments/:id/reject", a.rejectCommentHandler)
+	handleAdmin(http.MethodGet, "/v1/reports", a.listReportsHandler)
+
+	// webhook routes
+	handleAdmin(http.MethodPost, "/v1/webhooks", a.createWebhookHandler)
+	handleAdmin(http.MethodGet, "/v1/webhooks", a.listWebhooksHandler)
+	handleAdmin(http.MethodGet, "/v1/webhooks/:id", a.displayWebhookHandler)
+	handleAdmin(http.MethodPatch, "/v1/webhooks/:id", a.updateWebhookHandler)
+	handleAdmin(http.MethodDelete, "/v1/webhooks/:id", a.deleteWebhookHandler)
+	handleAdmin(http.MethodGet, "/v1/webhooks/:id/deliveries", a.listWebhookDeliveriesHandler)
+
+	if a.config.admin.token == "" {
+		a.logger.Warn("no admin token is set, so the moderation, report and webhook routes refuse every request")
+	}
+
+	// A Cache-Control policy for a route that doesn't exist is a typo
+	for route := range a.config.cache.control {
+		if handler, _, _ := router.Lookup(http.MethodGet, route); handler == nil {
+			a.logger.Warn("cache-control configured for unknown route", "route", route)
+		}
+	}
+	// and so is a body limit for a route nothing can be sent to
+	for route := range a.config.server.bodyLimits {
+		found := false
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch} {
+			if handler, _, _ := router.Lookup(method, route); handler != nil {
+				found = true
+			}
+		}
+		if !found {
+			a.logger.Warn("body limit configured for unknown route", "route", route)
+		}
+	}
 
-	router.HandlerFunc(http.MethodGet, "/v1/comments/:id", a.displayCommentHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/comments/:id", a.updateCommentHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/comments/:id", a.deleteCommentHandler)
+	// Anything but a GET of the stream goes to the router, just as it
+	// would if httprouter could register it
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == commentStreamPath {
+			stream(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
 
-	return a.recoverPanic(a.enableCORS(a.rateLimit(router)))
+	return a.requestID(a.strictTransportSecurity(a.realIP(a.traceRequest(a.recordMetrics(a.logRequest(a.recoverPanic(a.enableCORS(a.rateLimit(a.readYourWrites(api))))))))))
 
 }
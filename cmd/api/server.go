@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	//"os"
 	"os/signal"
 	"syscall"
@@ -18,14 +19,38 @@ import (
 // serve starts the HTTP server
 func (app *application) serve() error {
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.routes(),
+		IdleTimeout:       app.config.server.idleTimeout,
+		ReadTimeout:       app.config.server.readTimeout,
+		ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+		WriteTimeout:      app.config.server.writeTimeout,
+		MaxHeaderBytes:    app.config.server.maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		Protocols:         app.protocols(),
 	}
 
+	tlsConfig, err := app.tlsConfig()
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+
+	// Plain HTTP on the redirect port sends clients over to HTTPS. It
+	// keeps going until the main server has finished shutting down.
+	if app.config.tls.redirectPort > 0 {
+		err = app.serveRedirects()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Shutdown doesn't interrupt requests, so tell long-lived ones (the
+	// comment feed) to finish up
+	srv.RegisterOnShutdown(func() {
+		close(app.shutdown)
+	})
+
 	// Buffer 1 so the goroutine can send without blocking.
 	shutdownErr := make(chan error, 1)
 
@@ -38,22 +63,59 @@ func (app *application) serve() error {
 
 		app.logger.Info("shutting down server...")
 
-		// Give in-flight requests up to 30s to finish.
-		timeoutCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// Fail readiness checks first so the load balancer stops sending
+		// us new requests before we stop accepting them
+		app.draining.Store(true)
+		time.Sleep(app.config.drainDelay)
+
+		// Give in-flight requests up to -shutdown-timeout to finish.
+		timeoutCtx, cancel := context.WithTimeout(context.Background(), app.config.server.shutdownTimeout)
 		defer cancel()
 
 		// Initiate graceful shutdown.
-		shutdownErr <- srv.Shutdown(timeoutCtx)
+		err := srv.Shutdown(timeoutCtx)
+
+		// Let the job workers finish what they are running (in the same
+		// time); anything cut short is retried by another instance
+		if app.jobs != nil {
+			app.logger.Info("waiting for background jobs to finish")
+			jobsErr := app.jobs.Stop(timeoutCtx)
+			if jobsErr != nil {
+				app.logger.Warn(jobsErr.Error())
+			} else {
+				app.logger.Info("background jobs stopped")
+			}
+		}
+
+		// Now nothing is serving requests, stop the background tasks
+		// (the event listener, the webhook dispatcher, ...) and wait for
+		// them, still within -shutdown-timeout
+		app.logger.Info("waiting for background tasks to stop")
+		bgErr := app.stopBackground(timeoutCtx)
+		if bgErr != nil {
+			app.logger.Warn(bgErr.Error())
+		} else {
+			app.logger.Info("background tasks stopped")
+		}
+
+		shutdownErr <- err
 	}()
 
 	app.logger.Info("starting server",
 		"addr", srv.Addr,
 		"env", app.config.env,
 		"version", version,
+		"tls", tlsConfig != nil,
+		"h2c", app.config.h2c,
 	)
 
-	// Start serving (blocks until error or Shutdown).
-	err := srv.ListenAndServe()
+	// Start serving (blocks until error or Shutdown). The certificate is
+	// already in srv.TLSConfig.
+	if tlsConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	// If it's not the expected "server closed" error, bubble up.
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
@@ -68,3 +130,37 @@ func (app *application) serve() error {
 	return nil
 }
 
+// serveRedirects listens on -tls-redirect-port and redirects everything
+// to HTTPS
+func (app *application) serveRedirects() error {
+	redirect := &http.Server{
+		Addr:              fmt.Sprintf(":%d", app.config.tls.redirectPort),
+		Handler:           http.HandlerFunc(app.redirectToHTTPS),
+		IdleTimeout:       app.config.server.idleTimeout,
+		ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+		MaxHeaderBytes:    app.config.server.maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	// Listen now so a port that is in use stops us starting
+	listener, err := net.Listen("tcp", redirect.Addr)
+	if err != nil {
+		return err
+	}
+
+	app.background("https redirect listener", func(ctx context.Context) {
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			redirect.Shutdown(shutdownCtx)
+		}()
+
+		app.logger.Info("redirecting to https", "addr", redirect.Addr)
+		err := redirect.Serve(listener)
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error("https redirect listener", "error", err)
+		}
+	})
+	return nil
+}
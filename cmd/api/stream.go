@@ -0,0 +1,220 @@
+// Filename: cmd/api/stream.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mickali02/qod/internal/data"
+	"github.com/mickali02/qod/internal/events"
+	"github.com/mickali02/qod/internal/validator"
+)
+
+// commentStreamPath is the live feed. httprouter won't let it share a
+// level with /v1/comments/:id, so it is served in front of the router
+// (see routes).
+const commentStreamPath = "/v1/comments/stream"
+
+const (
+	streamCatchUpBatch = 100             // events read from the log at a time
+	streamPollInterval = 2 * time.Second // used when the event bus is off
+	streamRetry        = 2 * time.Second // how soon a client should reconnect
+)
+
+// streamCommentsHandler sends comment changes as Server-Sent Events:
+// created, updated and deleted. A client that reconnects with
+// Last-Event-ID (or ?last_event_id=) first gets whatever it missed from
+// the comment_events log. ?content= and ?author= filter the feed the
+// same way they filter GET /v1/comments.
+func (a *application) streamCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+	content := a.getSingleQueryParameter(queryParameters, "content", "")
+	author := a.getSingleQueryParameter(queryParameters, "author", "")
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = queryParameters.Get("last_event_id")
+	}
+
+	v := validator.New()
+	lastID := int64(-1)
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		v.Check(err == nil && id >= 0, "last_event_id", "must be a non-negative integer")
+		lastID = id
+	}
+	v.Check(len(content) <= 100, "content", "must not be more than 100 bytes long")
+	v.Check(len(author) <= 25, "author", "must not be more than 25 bytes long")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Subscribe before reading the log so nothing slips in between. The
+	// events are usually pushed to us; without the bus we poll the log.
+	var (
+		live <-chan events.Event
+		poll <-chan time.Time
+	)
+	if a.events != nil {
+		sub := a.events.Subscribe(64)
+		defer sub.Unsubscribe()
+		live = sub.C
+	} else {
+		ticker := time.NewTicker(streamPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	// A new client only wants what happens from now on
+	if lastID < 0 {
+		var err error
+		lastID, err = a.eventModel.LatestID(r.Context())
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	stream := &eventStream{w: w, rc: http.NewResponseController(w), timeout: a.config.stream.heartbeat + 10*time.Second}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	err := stream.write(fmt.Sprintf("retry: %d\n\n", streamRetry.Milliseconds()))
+	if err != nil {
+		a.logError(r, err)
+		return
+	}
+
+	a.metrics.streamClients.Inc()
+	defer a.metrics.streamClients.Dec()
+
+	// send passes one change on if this client should see it
+	send := func(event events.Event) error {
+		if event.ID <= lastID {
+			return nil // already sent while catching up
+		}
+		lastID = event.ID
+
+		name, payload, ok := feedEvent(event, content, author)
+		if !ok {
+			return nil
+		}
+		js, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		return stream.write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, name, js))
+	}
+
+	// catchUp sends everything in the log after lastID
+	catchUp := func(ctx context.Context) error {
+		for {
+			batch, err := a.eventModel.Since(ctx, lastID, streamCatchUpBatch)
+			if err != nil {
+				return err
+			}
+			for _, event := range batch {
+				err = send(event)
+				if err != nil {
+					return err
+				}
+			}
+			if len(batch) < streamCatchUpBatch {
+				return nil
+			}
+		}
+	}
+
+	err = catchUp(r.Context())
+
+	heartbeat := time.NewTicker(a.config.stream.heartbeat)
+	defer heartbeat.Stop()
+
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.shutdown:
+			// serve() is shutting down; the client will reconnect
+			// to another instance using Last-Event-ID
+			return
+		case event, ok := <-live:
+			if !ok {
+				return
+			}
+			if event.Type == events.Resync {
+				err = catchUp(r.Context())
+			} else {
+				err = send(event)
+			}
+		case <-poll:
+			err = catchUp(r.Context())
+		case <-heartbeat.C:
+			// A comment line keeps proxies from closing an idle stream
+			err = stream.write(": ping\n\n")
+		}
+	}
+
+	// The headers have gone, so all we can do is log why we stopped
+	if !errors.Is(err, context.Canceled) {
+		a.logError(r, err)
+	}
+}
+
+// feedEvent turns a change into the event a feed client sees. Comments
+// that aren't published don't exist as far as the feed is concerned (see
+// data.PublicChange).
+func feedEvent(event events.Event, content string, author string) (string, envelope, bool) {
+	eventType, ok := data.PublicChange(event)
+	if !ok || !data.MatchesSearch(event.Content, event.Author, content, author) {
+		return "", nil, false
+	}
+
+	comment := &data.Comment{
+		ID:      event.CommentID,
+		Content: event.Content,
+		Author:  event.Author,
+		Status:  event.Status,
+		Version: event.Version,
+	}
+
+	switch eventType {
+	case events.CommentCreated:
+		return "created", envelope{"comment": comment}, true
+	case events.CommentUpdated:
+		return "updated", envelope{"comment": comment}, true
+	default:
+		return "deleted", envelope{"comment": envelope{"id": event.CommentID}}, true
+	}
+}
+
+// eventStream writes to a Server-Sent Events response. Each write pushes
+// the server's WriteTimeout back, so a stream can outlive it as long as
+// something (if only a heartbeat) is sent regularly.
+type eventStream struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (s *eventStream) write(message string) error {
+	err := s.rc.SetWriteDeadline(time.Now().Add(s.timeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	_, err = fmt.Fprint(s.w, message)
+	if err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
@@ -0,0 +1,149 @@
+// Filename: cmd/api/stream_test.go
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mickali02/qod/internal/data"
+	"github.com/mickali02/qod/internal/events"
+	"github.com/mickali02/qod/internal/tracing"
+)
+
+func TestFeedEvent(t *testing.T) {
+	const (
+		published = data.StatusPublished
+		pending   = data.StatusPending
+		rejected  = data.StatusRejected
+	)
+
+	tests := []struct {
+		name     string
+		event    events.Event
+		wantName string
+		wantShow bool
+	}{
+		{"published comment created", events.Event{Type: events.CommentCreated, Status: published}, "created", true},
+		{"pending comment created", events.Event{Type: events.CommentCreated, Status: pending}, "", false},
+		{"published comment edited", events.Event{Type: events.CommentUpdated, Status: published, PreviousStatus: published}, "updated", true},
+		{"pending comment approved", events.Event{Type: events.CommentUpdated, Status: published, PreviousStatus: pending}, "created", true},
+		{"published comment hidden", events.Event{Type: events.CommentUpdated, Status: pending, PreviousStatus: published}, "deleted", true},
+		{"published comment rejected", events.Event{Type: events.CommentUpdated, Status: rejected, PreviousStatus: published}, "deleted", true},
+		{"pending comment rejected", events.Event{Type: events.CommentUpdated, Status: rejected, PreviousStatus: pending}, "", false},
+		{"pending comment edited", events.Event{Type: events.CommentUpdated, Status: pending, PreviousStatus: pending}, "", false},
+		{"update without a previous status, published", events.Event{Type: events.CommentUpdated, Status: published}, "updated", true},
+		{"update without a previous status, hidden", events.Event{Type: events.CommentUpdated, Status: pending}, "deleted", true},
+		{"published comment deleted", events.Event{Type: events.CommentDeleted, Status: published}, "deleted", true},
+		{"pending comment deleted", events.Event{Type: events.CommentDeleted, Status: pending}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.CommentID = 7
+			tt.event.Content = "hello"
+			tt.event.Author = "sam"
+
+			name, payload, show := feedEvent(tt.event, "", "")
+			if name != tt.wantName || show != tt.wantShow {
+				t.Fatalf("got %q, %v; want %q, %v", name, show, tt.wantName, tt.wantShow)
+			}
+			if !show {
+				return
+			}
+
+			// A deleted comment is only identified; the others are sent whole
+			switch comment := payload["comment"].(type) {
+			case envelope:
+				if name != "deleted" || comment["id"] != int64(7) {
+					t.Errorf("got payload %v", payload)
+				}
+			case *data.Comment:
+				if name == "deleted" || comment.ID != 7 || comment.Content != "hello" {
+					t.Errorf("got payload %+v", comment)
+				}
+			default:
+				t.Errorf("got payload %v", payload)
+			}
+		})
+	}
+}
+
+func TestFeedEventFilters(t *testing.T) {
+	event := events.Event{Type: events.CommentCreated, Content: "The quick fox", Author: "Sam", Status: data.StatusPublished}
+
+	if _, _, show := feedEvent(event, "quick", "sam"); !show {
+		t.Error("a matching comment was not shown")
+	}
+	if _, _, show := feedEvent(event, "dog", ""); show {
+		t.Error("a comment not matching ?content= was shown")
+	}
+	if _, _, show := feedEvent(event, "", "alex"); show {
+		t.Error("a comment not matching ?author= was shown")
+	}
+}
+
+// spanRecorder is a tracing.Exporter that keeps the names of the spans
+type spanRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *spanRecorder) Export(spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, span := range spans {
+		r.names = append(r.names, span.Name)
+	}
+	return nil
+}
+
+func TestCommentStreamRoute(t *testing.T) {
+	app := newTestApplication(t)
+	app.addComment(t, "hello", "Mickali", data.StatusPublished)
+
+	recorder := &spanRecorder{}
+	tracer := tracing.New(recorder, func(err error) { t.Error(err) })
+	tracing.SetDefault(tracer)
+	t.Cleanup(func() { tracing.SetDefault(nil) })
+
+	// A bad Last-Event-ID is turned away before the stream (or the
+	// database) is touched
+	res := app.do(t, http.MethodGet, commentStreamPath+"?last_event_id=-1", nil)
+	if res.status != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d (%s)", res.status, res.body)
+	}
+	if got := res.header.Get("Cache-Control"); got != "" {
+		t.Errorf("the stream got /v1/comments/:id's Cache-Control: %q", got)
+	}
+
+	// The comment route is unaffected
+	res = app.do(t, http.MethodGet, "/v1/comments/1", nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d (%s)", res.status, res.body)
+	}
+
+	err := tracer.Shutdown(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.mu.Lock()
+	names := recorder.names
+	recorder.mu.Unlock()
+	for _, want := range []string{
+		"GET " + commentStreamPath, "streamCommentsHandler",
+		"GET /v1/comments/:id", "displayCommentHandler",
+	} {
+		if !slices.Contains(names, want) {
+			t.Errorf("no span named %q in %q", want, names)
+		}
+	}
+
+	res = app.do(t, http.MethodGet, "/v1/metrics", nil)
+	want := `qod_http_requests_total{route="/v1/comments/stream",method="GET",status="422"} 1`
+	if !strings.Contains(string(res.body), want) {
+		t.Errorf("metrics are missing\n%s\ngot:\n%s", want, res.body)
+	}
+}
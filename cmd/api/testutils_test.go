@@ -0,0 +1,120 @@
+// Filename: cmd/api/testutils_test.go
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mickali02/qod/internal/data"
+)
+
+// newTestApplication returns an application with the default settings
+// that keeps its comments in memory. The rate limiter, cache and
+// anything else that needs PostgreSQL is turned off.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	cfg := defaultConfig()
+	cfg.limiter.enabled = false
+	cfg.cache.enabled = false
+	cfg.events.enabled = false
+	cfg.webhooks.enabled = false
+	cfg.jobs.enabled = false
+
+	// Nothing connects to it (sql.Open doesn't), but the pool gauges in
+	// /v1/metrics read its statistics
+	db, err := sql.Open("postgres", "postgres://qod-test.invalid/comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	app := &application{
+		config:       cfg,
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		db:           db,
+		commentModel: data.NewMemoryCommentStore(),
+		moderator:    data.NewDefaultModerator(nil, 2, 5),
+		shutdown:     make(chan struct{}),
+		tasks:        newBackgroundTasks(),
+		startedAt:    time.Now(),
+	}
+	app.metrics = app.newAppMetrics()
+	t.Cleanup(func() { app.tasks.cancel() })
+
+	return app
+}
+
+// testResponse is what a request to the test application got back
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// decode unmarshals the response body into dst
+func (res testResponse) decode(t *testing.T, dst any) {
+	t.Helper()
+
+	err := json.Unmarshal(res.body, dst)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", res.body, err)
+	}
+}
+
+// do sends a request through the application's routes and middleware.
+// A non-nil body is sent as it is if it is a string, and as JSON
+// otherwise.
+func (a *application) do(t *testing.T, method string, target string, body any) testResponse {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	default:
+		js, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(js)
+	}
+
+	return a.send(t, httptest.NewRequest(method, target, reader))
+}
+
+// send sends r through the application's routes and middleware
+func (a *application) send(t *testing.T, r *http.Request) testResponse {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	a.routes().ServeHTTP(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testResponse{status: res.StatusCode, header: res.Header, body: resBody}
+}
+
+// addComment stores a comment directly, skipping moderation
+func (a *application) addComment(t *testing.T, content string, author string, status string) *data.Comment {
+	t.Helper()
+
+	comment := &data.Comment{Content: content, Author: author, Status: status}
+	err := a.commentModel.Insert(t.Context(), comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comment
+}
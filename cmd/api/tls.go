@@ -0,0 +1,148 @@
+// Filename: cmd/api/tls.go
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// tlsEnabled reports whether the API is served over HTTPS
+func (c configuration) tlsEnabled() bool {
+	return c.tls.certFile != "" || c.tls.selfSigned
+}
+
+// tlsConfig returns the TLS settings for the server, or nil when we are
+// serving plain HTTP
+func (a *application) tlsConfig() (*tls.Config, error) {
+	if !a.config.tlsEnabled() {
+		return nil, nil
+	}
+
+	var (
+		cert tls.Certificate
+		err  error
+	)
+	if a.config.tls.selfSigned {
+		cert, err = selfSignedCertificate([]string{"localhost", "127.0.0.1", "::1"})
+		if err == nil {
+			sum := sha256.Sum256(cert.Certificate[0])
+			a.logger.Warn("serving with a self-signed certificate; clients will not trust it",
+				"sha256", hex.EncodeToString(sum[:]))
+		}
+	} else {
+		cert, err = tls.LoadX509KeyPair(a.config.tls.certFile, a.config.tls.keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// Key exchanges with forward secrecy, starting with the hybrid
+		// post-quantum one
+		CurvePreferences: []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256},
+		// Only AEAD ciphers for TLS 1.2 (TLS 1.3 suites are always
+		// secure and can't be configured)
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}, nil
+}
+
+// selfSignedCertificate makes a throwaway certificate for hosts, so
+// HTTPS can be tried out in development without creating one
+func selfSignedCertificate(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"qod development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(30 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// protocols returns the HTTP versions the server speaks. HTTP/2 is used
+// over TLS when the client supports it; -h2c also allows it without TLS
+// (with prior knowledge), which is handy behind a proxy that talks
+// HTTP/2 to us over a trusted network.
+func (a *application) protocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(a.config.h2c)
+	return protocols
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the HTTPS
+// port (see -tls-redirect-port)
+func (a *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		http.Error(w, "missing Host header", http.StatusBadRequest)
+		return
+	}
+
+	if a.config.port == 443 {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+	} else {
+		host = net.JoinHostPort(host, strconv.Itoa(a.config.port))
+	}
+
+	target := url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     r.URL.Path,
+		RawPath:  r.URL.RawPath,
+		RawQuery: r.URL.RawQuery,
+	}
+	// 308 keeps the method and body, unlike 301
+	http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,84 @@
+// Filename: cmd/api/tracing.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/mickali02/qod/internal/tracing"
+)
+
+// setupTracer creates the tracer chosen with the -otel-exporter flag and
+// makes it the default. It returns nil when tracing is turned off.
+func setupTracer(cfg configuration, logger *slog.Logger) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+
+	switch cfg.otel.exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exporter = &tracing.StdoutExporter{W: os.Stdout}
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.otel.endpoint, cfg.otel.serviceName)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.otel.exporter)
+	}
+
+	tracer := tracing.New(exporter, func(err error) {
+		logger.Error("exporting spans", "error", err)
+	})
+	tracing.SetDefault(tracer)
+	return tracer, nil
+}
+
+// traceRequest starts a server span for each request. If the caller sent
+// a W3C traceparent header the span joins the caller's trace.
+func (a *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = a.contextSetRoute(r)
+		ctx := r.Context()
+		if parent, ok := tracing.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, parent)
+		}
+
+		ctx, span := tracing.Start(ctx, "HTTP "+r.Method, tracing.WithKind(tracing.KindServer))
+		defer span.End()
+
+		ip, _ := a.contextGetClientIP(r)
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", ip)
+		span.SetAttribute("request_id", a.contextGetRequestID(r))
+
+		rw := newResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		route := a.contextGetRoute(r)
+		span.SetName(r.Method + " " + route)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.response.status_code", rw.status)
+		if rw.status >= 500 {
+			span.RecordError(fmt.Errorf("%d %s", rw.status, http.StatusText(rw.status)))
+		}
+	})
+}
+
+// traceHandler wraps a handler in its own span so that handler time can
+// be told apart from time spent in the middleware. The span is named
+// after the handler method (e.g. createCommentHandler).
+func (a *application) traceHandler(next http.HandlerFunc) http.HandlerFunc {
+	name := runtime.FuncForPC(reflect.ValueOf(next).Pointer()).Name()
+	name = strings.TrimSuffix(name[strings.LastIndex(name, ".")+1:], "-fm")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), name)
+		defer span.End()
+
+		next(w, r.WithContext(ctx))
+	}
+}
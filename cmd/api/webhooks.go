@@ -0,0 +1,318 @@
+// Filename: cmd/api/webhooks.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mickali02/qod/internal/data"
+	"github.com/mickali02/qod/internal/validator"
+	"github.com/mickali02/qod/internal/webhooks"
+)
+
+// startWebhookDispatcher sends the outbox to the registered webhooks.
+// Every API instance can run one; they share the work through the
+// database. Comment events wake it so deliveries go out straight away.
+func (a *application) startWebhookDispatcher() {
+	cfg := a.config.webhooks
+
+	dispatcher := &webhooks.Dispatcher{
+		Store:       a.webhookModel,
+		Client:      webhooks.NewClient(cfg.timeout, cfg.allowPrivate),
+		Workers:     cfg.workers,
+		MaxAttempts: cfg.maxAttempts,
+		Backoff:     cfg.backoff,
+		MaxBackoff:  cfg.maxBackoff,
+		Interval:    cfg.pollInterval,
+		OnError: func(err error) {
+			a.logger.Error("webhook dispatcher", "error", err)
+		},
+		OnAttempt: func(delivery webhooks.Delivery, attempt webhooks.Attempt) {
+			result := "retrying"
+			switch {
+			case attempt.Succeeded:
+				result = "succeeded"
+			case attempt.NextAttemptAt.IsZero():
+				result = "failed"
+				a.logger.Warn("webhook delivery failed", "delivery_id", delivery.ID,
+					"webhook_id", delivery.WebhookID, "attempts", attempt.Attempt, "error", attempt.Error)
+			}
+			a.metrics.webhooks.Inc(result)
+		},
+	}
+
+	wake := make(chan struct{}, 1)
+	if a.events != nil {
+		sub := a.events.Subscribe(16)
+		a.background("webhook waker", func(ctx context.Context) {
+			defer sub.Unsubscribe()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-sub.C:
+					select {
+					case wake <- struct{}{}:
+					default: // already due to wake up
+					}
+				}
+			}
+		})
+	}
+
+	a.background("webhook dispatcher", func(ctx context.Context) {
+		dispatcher.Run(ctx, wake)
+	})
+}
+
+// newWebhookSecret returns a random secret for signing deliveries
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// createWebhookHandler registers a webhook. The response is the only
+// time the signing secret is shown.
+func (a *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:    incomingData.URL,
+		Events: incomingData.Events,
+		Active: true,
+	}
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	v := validator.New()
+	data.ValidateWebhook(v, webhook)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	webhook.Secret, err = newWebhookSecret()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.webhookModel.Insert(r.Context(), webhook)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	response := envelope{"webhook": webhook, "secret": webhook.Secret}
+	err = a.writeJSON(w, http.StatusCreated, response, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+
+	filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
+	filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "id")
+	filters.SortSafeList = []string{"id", "created_at", "-id", "-created_at"}
+
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hooks, metadata, err := a.webhookModel.GetAll(r.Context(), filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	response := envelope{"webhooks": hooks, "@metadata": metadata}
+	err = a.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// getWebhook loads the webhook named in the URL, writing the error
+// response itself if it can't
+func (a *application) getWebhook(w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	webhook, err := a.webhookModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return webhook, true
+}
+
+func (a *application) displayWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := a.getWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWebhookHandler changes a webhook's URL or events, or pauses it
+// ("active": false). Deliveries for a paused webhook wait until it is
+// active again.
+func (a *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := a.getWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var incomingData struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if incomingData.URL != nil {
+		webhook.URL = *incomingData.URL
+	}
+	if incomingData.Events != nil {
+		webhook.Events = incomingData.Events
+	}
+	if incomingData.Active != nil {
+		webhook.Active = *incomingData.Active
+	}
+
+	v := validator.New()
+	data.ValidateWebhook(v, webhook)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.webhookModel.Update(r.Context(), webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.webhookModel.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWebhookDeliveriesHandler shows the delivery log: what was sent to
+// a webhook, newest first, and how each attempt went
+func (a *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := a.getWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+
+	status := a.getSingleQueryParameter(queryParameters, "status", "")
+	filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
+	filters.Sort = "-id"
+	filters.SortSafeList = []string{"-id"}
+
+	data.ValidateFilters(v, filters)
+	if status != "" {
+		v.Check(validator.PermittedValue(status, data.DeliveryPending, data.DeliverySucceeded, data.DeliveryFailed), "status", "invalid status value")
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := a.webhookModel.Deliveries(r.Context(), webhook.ID, status, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	response := envelope{"deliveries": deliveries, "@metadata": metadata}
+	err = a.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
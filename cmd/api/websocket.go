@@ -0,0 +1,277 @@
+// Filename: cmd/api/websocket.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mickali02/qod/internal/events"
+	"github.com/mickali02/qod/internal/websocket"
+	"golang.org/x/time/rate"
+)
+
+// wsMessage is everything that goes either way over the socket.
+//
+// The client sends:
+//
+//	{"type":"subscribe","comment_id":5}    changes to comment 5
+//	{"type":"subscribe","all":true}        changes to every comment, including new ones
+//	{"type":"unsubscribe","comment_id":5}  (or "all":true)
+//	{"type":"typing","comment_id":5}       tell the others watching comment 5
+//
+// and receives "subscribed"/"unsubscribed" replies, "created", "updated"
+// and "deleted" events shaped like the SSE feed's, "typing" from other
+// clients, "resync" when it may have missed events, and "error".
+type wsMessage struct {
+	Type      string `json:"type"`
+	CommentID int64  `json:"comment_id,omitempty"`
+	All       bool   `json:"all,omitempty"`
+	EventID   int64  `json:"event_id,omitempty"`
+	Comment   any    `json:"comment,omitempty"`
+	Client    string `json:"client,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsMaxMessageSize = 4096 // client messages are tiny
+	wsSendBuffer     = 32   // replies and typing notices waiting to be written
+	wsEventBuffer    = 64   // comment events waiting to be written
+)
+
+// wsClient is one connection
+type wsClient struct {
+	id   string
+	conn *websocket.Conn
+	send chan wsMessage // everything except comment events
+
+	mu       sync.Mutex
+	all      bool
+	comments map[int64]bool
+}
+
+// wsHub keeps track of the clients so typing notices can reach them
+type wsHub struct {
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+	nextID  atomic.Int64
+}
+
+func (h *wsHub) add(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients == nil {
+		h.clients = make(map[*wsClient]struct{})
+	}
+	h.clients[client] = struct{}{}
+}
+
+func (h *wsHub) remove(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+}
+
+// typing tells every other client watching commentID. Typing notices
+// only reach clients of this instance, and are dropped for clients that
+// are behind; they are not worth more than that.
+func (h *wsHub) typing(from *wsClient, commentID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		if client == from || !client.watching(commentID) {
+			continue
+		}
+		select {
+		case client.send <- wsMessage{Type: "typing", CommentID: commentID, Client: from.id}:
+		default:
+		}
+	}
+}
+
+func (c *wsClient) watching(commentID int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.all || c.comments[commentID]
+}
+
+// websocketHandler upgrades the connection and speaks the wsMessage
+// protocol until either side hangs up
+func (a *application) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	if a.events == nil {
+		a.errorResponseJSON(w, r, http.StatusServiceUnavailable, "live updates are not enabled")
+		return
+	}
+
+	// Browsers let any page open a WebSocket, so only accept pages from
+	// the trusted origins (non-browser clients send no Origin)
+	if origin := r.Header.Get("Origin"); origin != "" && !slices.Contains(a.config.cors.trustedOrigins, origin) {
+		a.errorResponseJSON(w, r, http.StatusForbidden, "origin not allowed")
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		a.logger.Debug("websocket upgrade failed", "error", err)
+		return
+	}
+	conn.MaxMessageSize = wsMaxMessageSize
+
+	client := &wsClient{
+		id:       "c" + strconv.FormatInt(a.ws.nextID.Add(1), 10),
+		conn:     conn,
+		send:     make(chan wsMessage, wsSendBuffer),
+		comments: make(map[int64]bool),
+	}
+	a.ws.add(client)
+	defer a.ws.remove(client)
+
+	a.metrics.wsClients.Inc()
+	defer a.metrics.wsClients.Dec()
+
+	sub := a.events.Subscribe(wsEventBuffer)
+	defer sub.Unsubscribe()
+
+	// A client that stops answering pings is gone
+	pingInterval := a.config.ws.pingInterval
+	conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	conn.PongHandler = func() {
+		conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go a.wsWriter(client, sub, done)
+
+	a.wsReader(client)
+}
+
+// wsReader handles the client's messages. It returns when the connection
+// is closed.
+func (a *application) wsReader(client *wsClient) {
+	defer client.conn.Close(websocket.CloseNormal, "")
+
+	limiter := rate.NewLimiter(10, 20)
+
+	for {
+		opcode, payload, err := client.conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				a.logger.Debug("websocket read failed", "client", client.id, "error", err)
+			}
+			return
+		}
+
+		if !limiter.Allow() {
+			client.conn.Close(websocket.ClosePolicyViolation, "too many messages")
+			return
+		}
+
+		var message wsMessage
+		if opcode != websocket.OpText || json.Unmarshal(payload, &message) != nil {
+			a.wsReply(client, wsMessage{Type: "error", Error: "messages must be JSON text"})
+			continue
+		}
+
+		switch message.Type {
+		case "subscribe", "unsubscribe":
+			if !message.All && message.CommentID < 1 {
+				a.wsReply(client, wsMessage{Type: "error", Error: "comment_id or all must be provided"})
+				continue
+			}
+			subscribe := message.Type == "subscribe"
+			client.mu.Lock()
+			if message.All {
+				client.all = subscribe
+			} else if subscribe {
+				client.comments[message.CommentID] = true
+			} else {
+				delete(client.comments, message.CommentID)
+			}
+			client.mu.Unlock()
+			a.wsReply(client, wsMessage{Type: message.Type + "d", CommentID: message.CommentID, All: message.All})
+
+		case "typing":
+			if message.CommentID < 1 {
+				a.wsReply(client, wsMessage{Type: "error", Error: "comment_id must be provided"})
+				continue
+			}
+			a.ws.typing(client, message.CommentID)
+
+		default:
+			a.wsReply(client, wsMessage{Type: "error", Error: "unknown message type"})
+		}
+	}
+}
+
+// wsReply queues a message for the client. A client too far behind to
+// take its own replies is disconnected.
+func (a *application) wsReply(client *wsClient, message wsMessage) {
+	select {
+	case client.send <- message:
+	default:
+		client.conn.Close(websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+// wsWriter is the only goroutine that writes messages to the client.
+// It passes on comment events, queued replies and heartbeats.
+func (a *application) wsWriter(client *wsClient, sub *events.Subscription, done <-chan struct{}) {
+	ping := time.NewTicker(a.config.ws.pingInterval)
+	defer ping.Stop()
+
+	write := func(message wsMessage) error {
+		js, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return client.conn.WriteMessage(websocket.OpText, js, time.Now().Add(wsWriteWait))
+	}
+
+	for {
+		var err error
+
+		select {
+		case <-done:
+			return
+		case <-a.shutdown:
+			client.conn.Close(websocket.CloseGoingAway, "server shutting down")
+			return
+		case message := <-client.send:
+			err = write(message)
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if event.Type == events.Resync {
+				// The events bus dropped some of ours (we were behind) or
+				// lost its connection; the client should reload
+				err = write(wsMessage{Type: "resync"})
+				break
+			}
+			if !client.watching(event.CommentID) {
+				break
+			}
+			name, payload, show := feedEvent(event, "", "")
+			if show {
+				err = write(wsMessage{Type: name, EventID: event.ID, CommentID: event.CommentID, Comment: payload["comment"]})
+			}
+		case <-ping.C:
+			err = client.conn.WriteControl(websocket.OpPing, nil, time.Now().Add(wsWriteWait))
+		}
+
+		if err != nil {
+			// The reader sees the connection close and cleans up
+			client.conn.Close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
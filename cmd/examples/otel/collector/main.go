@@ -0,0 +1,43 @@
+// Filename: cmd/examples/otel/collector/main.go
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "io"
+    "log"
+    "net/http"
+)
+
+// A stand-in for an OpenTelemetry collector. It accepts OTLP/HTTP JSON on
+// POST /v1/traces and prints what it receives, so we can check our spans
+// without running a real collector. Start the API with:
+//     go run ./cmd/api -otel-exporter=otlp -otel-endpoint=http://localhost:4318
+func main() {
+    addr := flag.String("addr", ":4318", "Server address")
+    flag.Parse()
+
+    http.HandleFunc("POST /v1/traces", func(w http.ResponseWriter, r *http.Request) {
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        var pretty bytes.Buffer
+        err = json.Indent(&pretty, body, "", "  ")
+        if err != nil {
+            http.Error(w, "body must be JSON", http.StatusBadRequest)
+            return
+        }
+        log.Printf("received spans:\n%s", pretty.String())
+
+        w.Header().Set("Content-Type", "application/json")
+        w.Write([]byte("{}"))
+    })
+
+    log.Printf("starting collector on %s", *addr)
+    err := http.ListenAndServe(*addr, nil)
+    log.Fatal(err)
+}
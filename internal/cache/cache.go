@@ -0,0 +1,20 @@
+// Filename: internal/cache/cache.go
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Store keeps byte values for a limited time. Memory is the built-in
+// store; anything shared between API replicas (Redis, memcached, ...)
+// can be plugged in by implementing this interface.
+type Store interface {
+	// Get returns the value for key and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key. A ttl of 0 means it never expires
+	// (though it may still be evicted).
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys; missing keys are not an error
+	Delete(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,115 @@
+// Filename: internal/cache/memory.go
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a least-recently-used cache which lives in this process.
+// Once it holds maxEntries values, adding another evicts the one that
+// was used longest ago.
+type Memory struct {
+	maxEntries int
+	now        func() time.Time // the clock; tests replace it
+
+	mu      sync.Mutex
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // zero means never
+}
+
+// NewMemory creates a cache holding up to maxEntries values
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, found := m.entries[key]
+	if !found {
+		return nil, false, nil
+	}
+
+	e := element.Value.(*entry)
+	if !e.expiresAt.IsZero() && m.now().After(e.expiresAt) {
+		m.remove(element)
+		return nil, false, nil
+	}
+
+	m.order.MoveToFront(element)
+	return e.value, true, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = m.now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, found := m.entries[key]; found {
+		e := element.Value.(*entry)
+		e.value = value
+		e.expiresAt = expiresAt
+		m.order.MoveToFront(element)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(&entry{key: key, value: value, expiresAt: expiresAt})
+
+	for m.order.Len() > m.maxEntries {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if element, found := m.entries[key]; found {
+			m.remove(element)
+		}
+	}
+	return nil
+}
+
+// Clear removes every value
+func (m *Memory) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.order.Init()
+	clear(m.entries)
+}
+
+// Len returns how many values are cached, including any that have
+// expired but haven't been looked up since
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+func (m *Memory) remove(element *list.Element) {
+	m.order.Remove(element)
+	delete(m.entries, element.Value.(*entry).key)
+}
@@ -0,0 +1,134 @@
+// Filename: internal/cache/memory_test.go
+package cache
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// keys returns the keys m holds, most recently used first
+func (m *Memory) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for element := m.order.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(*entry).key)
+	}
+	return keys
+}
+
+// get looks key up, failing the test on an error
+func get(t *testing.T, m *Memory, key string) (string, bool) {
+	t.Helper()
+
+	value, found, err := m.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(value), found
+}
+
+func set(t *testing.T, m *Memory, key string, value string, ttl time.Duration) {
+	t.Helper()
+
+	err := m.Set(context.Background(), key, []byte(value), ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryEvictsLeastRecentlyUsed(t *testing.T) {
+	m := NewMemory(3)
+	set(t, m, "a", "1", 0)
+	set(t, m, "b", "2", 0)
+	set(t, m, "c", "3", 0)
+
+	// Reading a makes b the least recently used...
+	if value, found := get(t, m, "a"); !found || value != "1" {
+		t.Fatalf("a = %q, %v", value, found)
+	}
+	// ...so it is the one that makes way for d
+	set(t, m, "d", "4", 0)
+	if got, want := m.keys(), []string{"d", "a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("keys = %q; want %q", got, want)
+	}
+	if _, found := get(t, m, "b"); found {
+		t.Error("b is still cached")
+	}
+
+	// Overwriting a value uses it too
+	set(t, m, "c", "33", 0)
+	set(t, m, "e", "5", 0)
+	if got, want := m.keys(), []string{"e", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("keys = %q; want %q", got, want)
+	}
+	if value, _ := get(t, m, "c"); value != "33" {
+		t.Errorf("c = %q; want 33", value)
+	}
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory(10)
+	m.now = func() time.Time { return now }
+
+	set(t, m, "short", "1", time.Second)
+	set(t, m, "long", "2", time.Minute)
+	set(t, m, "forever", "3", 0)
+
+	now = now.Add(time.Second)
+	if _, found := get(t, m, "short"); !found {
+		t.Error("short expired on its last second")
+	}
+
+	now = now.Add(time.Millisecond)
+	if _, found := get(t, m, "short"); found {
+		t.Error("short didn't expire")
+	}
+	// and the expired value was dropped when it was looked up
+	if m.Len() != 2 {
+		t.Errorf("Len = %d; want 2", m.Len())
+	}
+
+	// Setting a value again starts its ttl again
+	now = now.Add(50 * time.Second)
+	set(t, m, "long", "22", time.Minute)
+	now = now.Add(30 * time.Second)
+	if value, found := get(t, m, "long"); !found || value != "22" {
+		t.Errorf("long = %q, %v", value, found)
+	}
+
+	now = now.Add(24 * 365 * time.Hour)
+	if _, found := get(t, m, "forever"); !found {
+		t.Error("a value without a ttl expired")
+	}
+}
+
+func TestMemoryDeleteAndClear(t *testing.T) {
+	m := NewMemory(10)
+	set(t, m, "a", "1", 0)
+	set(t, m, "b", "2", 0)
+	set(t, m, "c", "3", 0)
+
+	err := m.Delete(context.Background(), "a", "missing", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.keys(); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("keys after Delete = %q", got)
+	}
+
+	m.Clear()
+	if _, found := get(t, m, "b"); found || m.Len() != 0 {
+		t.Errorf("Clear left %d values", m.Len())
+	}
+
+	// The cache still works afterwards
+	set(t, m, "d", "4", 0)
+	if value, found := get(t, m, "d"); !found || value != "4" {
+		t.Errorf("d = %q, %v", value, found)
+	}
+}
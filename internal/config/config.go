@@ -0,0 +1,252 @@
+// Filename: internal/config/config.go
+
+// Package config reads a configuration file into a flat map of flag
+// names to values. It understands the small parts of YAML and TOML that
+// a config file needs: nested tables/mappings, strings, numbers,
+// booleans and lists. Nested keys are joined with "-", so
+//
+//	db:
+//	  query_timeout: 5s
+//
+// and
+//
+//	[db]
+//	query-timeout = "5s"
+//
+// both become "db-query-timeout" = "5s". Lists are joined with spaces,
+// which is how the list flags expect them.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadFile parses a .yaml, .yml or .toml file
+func ReadFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = ParseYAML(file)
+	case ".toml":
+		values, err = ParseTOML(file)
+	default:
+		return nil, fmt.Errorf("config: %s: unsupported file type (want .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// ParseYAML reads block mappings, "- item" lists and [a, b] lists
+func ParseYAML(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+
+	// parents holds the mapping keys above the current line along with
+	// their indentation
+	type parent struct {
+		key    string
+		indent int
+	}
+	var parents []parent
+	listKey := "" // the key whose "- item" lines we are reading
+	listIndent := -1
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" || strings.TrimSpace(line) == "---" {
+			continue
+		}
+		if strings.Contains(line, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", lineNumber)
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		text := strings.TrimSpace(line)
+
+		// A list item belongs to the key that opened it
+		if strings.HasPrefix(text, "- ") || text == "-" {
+			if listKey == "" || indent < listIndent {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNumber)
+			}
+			item, err := scalar(strings.TrimSpace(strings.TrimPrefix(text, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			values[listKey] = strings.TrimSpace(values[listKey] + " " + item)
+			continue
+		}
+		listKey = ""
+
+		key, value, found := strings.Cut(text, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNumber)
+		}
+		key = normalizeKey(key)
+		value = strings.TrimSpace(value)
+
+		// Leave any mappings we have dedented out of
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		path := make([]string, 0, len(parents)+1)
+		for _, p := range parents {
+			path = append(path, p.key)
+		}
+		fullKey := strings.Join(append(path, key), "-")
+
+		if value == "" {
+			// Either a nested mapping or a block list follows
+			parents = append(parents, parent{key: key, indent: indent})
+			listKey = fullKey
+			listIndent = indent
+			continue
+		}
+
+		if strings.HasPrefix(value, "[") {
+			values[fullKey], found = inlineList(value)
+			if !found {
+				return nil, fmt.Errorf("line %d: unterminated list", lineNumber)
+			}
+			continue
+		}
+
+		parsed, err := scalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		values[fullKey] = parsed
+	}
+
+	return values, scanner.Err()
+}
+
+// ParseTOML reads [tables], key = value pairs, dotted keys and
+// single-line arrays
+func ParseTOML(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	table := ""
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") || strings.HasPrefix(text, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header", lineNumber)
+			}
+			table = normalizeKey(strings.Trim(text, "[]"))
+			continue
+		}
+
+		key, value, found := strings.Cut(text, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected \"key = value\"", lineNumber)
+		}
+		key = normalizeKey(key)
+		if table != "" {
+			key = table + "-" + key
+		}
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, "[") {
+			values[key], found = inlineList(value)
+			if !found {
+				return nil, fmt.Errorf("line %d: unterminated array", lineNumber)
+			}
+			continue
+		}
+
+		parsed, err := scalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		values[key] = parsed
+	}
+
+	return values, scanner.Err()
+}
+
+// normalizeKey turns "db.query_timeout" into "db-query-timeout"
+func normalizeKey(key string) string {
+	key = strings.TrimSpace(key)
+	key = strings.Trim(key, `"'`)
+	key = strings.NewReplacer(".", "-", "_", "-").Replace(key)
+	return strings.ToLower(key)
+}
+
+// scalar unquotes a string value. Anything else is returned as written
+// and left to the flag to parse.
+func scalar(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch value[0] {
+	case '"':
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return unquoted, nil
+	case '\'':
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	}
+	return value, nil
+}
+
+// inlineList turns [a, "b", 'c'] into "a b c"
+func inlineList(value string) (string, bool) {
+	if !strings.HasSuffix(value, "]") {
+		return "", false
+	}
+	items := []string{}
+	for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+		item, err := scalar(strings.TrimSpace(item))
+		if err != nil || item == "" {
+			continue
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, " "), true
+}
+
+// stripComment removes a # comment that isn't inside quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -9,6 +9,8 @@ import (
 	"fmt"
 
 	// This is the validator from slides 165-168
+	"github.com/mickali02/qod/internal/events"
+	"github.com/mickali02/qod/internal/tracing"
 	"github.com/mickali02/qod/internal/validator"
 )
 
@@ -17,6 +19,7 @@ type Comment struct {
 	ID        int64     `json:"id"`
 	Content   string    `json:"content"`
 	Author    string    `json:"author"`
+	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"-"` // The "-" tag means this field will be hidden in JSON responses
 	Version   int32     `json:"version"`
 }
@@ -30,45 +33,131 @@ func ValidateComment(v *validator.Validator, comment *Comment) {
 	v.Check(len(comment.Author) <= 25, "author", "must not be more than 25 bytes long")
 }
 
+// startSpan begins a tracing span for a database call
+func startSpan(ctx context.Context, name string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, name, tracing.WithKind(tracing.KindClient))
+	span.SetAttribute("db.system", "postgresql")
+	return ctx, span
+}
+
+// CommentStore is everything the handlers need from comment storage.
+// CommentModel keeps comments in PostgreSQL; MemoryCommentStore keeps
+// them in a map, which is handy for tests and local experiments.
+type CommentStore interface {
+	Insert(ctx context.Context, comment *Comment) error
+	Get(ctx context.Context, id int64) (*Comment, error)
+	Update(ctx context.Context, comment *Comment) error
+	Delete(ctx context.Context, id int64) error
+	UpdateStatus(ctx context.Context, id int64, status string) error
+	GetAll(ctx context.Context, content string, author string, status string, filters Filters) ([]*Comment, Metadata, error)
+}
+
 // This is the CommentModel from slide 181
 type CommentModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	Replicas     *ReplicaSet   // Get and GetAll read from these when there are any
+	QueryTimeout time.Duration // how long a single query may run (default 3s)
+}
+
+// queryTimeout returns the time limit for one query
+func (c CommentModel) queryTimeout() time.Duration {
+	if c.QueryTimeout > 0 {
+		return c.QueryTimeout
+	}
+	return 3 * time.Second
+}
+
+// read runs query against a replica, or against the primary when there
+// is no healthy replica or ctx asked for it (see WithPrimary). A replica
+// that can't be reached is taken out of rotation and the query is run
+// again on the primary.
+func (c CommentModel) read(ctx context.Context, span *tracing.Span, query func(db *sql.DB) error) error {
+	var r *replica
+	if !usePrimary(ctx) {
+		r = c.Replicas.pick()
+	}
+	if r == nil {
+		span.SetAttribute("db.instance", "primary")
+		return query(c.DB)
+	}
+
+	span.SetAttribute("db.instance", r.name)
+	err := query(r.db)
+	if err != nil && isConnectionError(err) && ctx.Err() == nil {
+		r.record(err)
+		span.SetAttribute("db.instance", "primary")
+		return query(c.DB)
+	}
+	return err
+}
+
+// inTx runs fn inside a transaction, committing it if fn returns nil
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // This is the Insert method from slides 182-183
-func (c CommentModel) Insert(comment *Comment) error {
+func (c CommentModel) Insert(ctx context.Context, comment *Comment) error {
 	query := `
-		 INSERT INTO comments (content, author)
-		 VALUES ($1, $2)
+		 INSERT INTO comments (content, author, status)
+		 VALUES ($1, $2, $3)
 		 RETURNING id, created_at, version`
 
-	args := []any{comment.Content, comment.Author}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{comment.Content, comment.Author, comment.Status}
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout())
 	defer cancel()
 
-	return c.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.Version)
+	ctx, span := startSpan(ctx, "CommentModel.Insert")
+	defer span.End()
+
+	err := inTx(ctx, c.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.Version)
+		if err != nil {
+			return err
+		}
+		return recordEvent(ctx, tx, events.Event{Type: events.CommentCreated, CommentID: comment.ID,
+			Content: comment.Content, Author: comment.Author, Status: comment.Status, Version: comment.Version})
+	})
+	span.RecordError(err)
+	return err
 }
 
 // This is the Get method from slides 191-193
-func (c CommentModel) Get(id int64) (*Comment, error) {
+func (c CommentModel) Get(ctx context.Context, id int64) (*Comment, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-		 SELECT id, created_at, content, author, version
+		 SELECT id, created_at, content, author, status, version
 		 FROM comments
 		 WHERE id = $1`
 
 	var comment Comment
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout())
 	defer cancel()
 
-	err := c.DB.QueryRowContext(ctx, query, id).Scan(&comment.ID, &comment.CreatedAt, &comment.Content, &comment.Author, &comment.Version)
+	ctx, span := startSpan(ctx, "CommentModel.Get")
+	defer span.End()
+
+	err := c.read(ctx, span, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, query, id).Scan(&comment.ID, &comment.CreatedAt, &comment.Content, &comment.Author, &comment.Status, &comment.Version)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
+			span.RecordError(err)
 			return nil, err
 		}
 	}
@@ -76,87 +165,160 @@ func (c CommentModel) Get(id int64) (*Comment, error) {
 }
 
 // This is the Update method from slides 208-209
-func (c CommentModel) Update(comment *Comment) error {
+func (c CommentModel) Update(ctx context.Context, comment *Comment) error {
+	// previous is the row as it was before the update; the feed needs to
+	// know whether the comment was published
 	query := `
 		UPDATE comments
-		SET content = $1, author = $2, version = version + 1
-		WHERE id = $3
-		RETURNING version`
+		SET content = $1, author = $2, status = $3, version = version + 1
+		FROM (SELECT status FROM comments WHERE id = $4 FOR UPDATE) AS previous
+		WHERE id = $4
+		RETURNING version, previous.status`
 
-	args := []any{comment.Content, comment.Author, comment.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{comment.Content, comment.Author, comment.Status, comment.ID}
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout())
 	defer cancel()
 
-	return c.DB.QueryRowContext(ctx, query, args...).Scan(&comment.Version)
+	ctx, span := startSpan(ctx, "CommentModel.Update")
+	defer span.End()
+
+	err := inTx(ctx, c.DB, func(tx *sql.Tx) error {
+		event := events.Event{Type: events.CommentUpdated, CommentID: comment.ID,
+			Content: comment.Content, Author: comment.Author, Status: comment.Status}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&comment.Version, &event.PreviousStatus)
+		if err != nil {
+			return err
+		}
+		event.Version = comment.Version
+		return recordEvent(ctx, tx, event)
+	})
+	span.RecordError(err)
+	return err
 }
 
 // This is the Delete method from slides 220-222
-func (c CommentModel) Delete(id int64) error {
+func (c CommentModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `
 		DELETE FROM comments
-		WHERE id = $1`
+		WHERE id = $1
+		RETURNING content, author, status`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout())
 	defer cancel()
 
-	result, err := c.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
+	ctx, span := startSpan(ctx, "CommentModel.Delete")
+	defer span.End()
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
+	err := inTx(ctx, c.DB, func(tx *sql.Tx) error {
+		// The feed needs to know what was deleted to apply its filters
+		event := events.Event{Type: events.CommentDeleted, CommentID: id}
+		err := tx.QueryRowContext(ctx, query, id).Scan(&event.Content, &event.Author, &event.Status)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+
+		return recordEvent(ctx, tx, event)
+	})
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		span.RecordError(err)
 	}
+	return err
+}
 
-	if rowsAffected == 0 {
+// UpdateStatus moves a comment into a new moderation status
+func (c CommentModel) UpdateStatus(ctx context.Context, id int64, status string) error {
+	if id < 1 {
 		return ErrRecordNotFound
 	}
+	query := `
+		UPDATE comments
+		SET status = $1, version = version + 1
+		FROM (SELECT status FROM comments WHERE id = $2 FOR UPDATE) AS previous
+		WHERE id = $2
+		RETURNING version, content, author, previous.status`
+
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout())
+	defer cancel()
 
-	return nil
+	ctx, span := startSpan(ctx, "CommentModel.UpdateStatus")
+	defer span.End()
+
+	err := inTx(ctx, c.DB, func(tx *sql.Tx) error {
+		event := events.Event{Type: events.CommentUpdated, CommentID: id, Status: status}
+		err := tx.QueryRowContext(ctx, query, status, id).Scan(&event.Version, &event.Content, &event.Author, &event.PreviousStatus)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+
+		return recordEvent(ctx, tx, event)
+	})
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		span.RecordError(err)
+	}
+	return err
 }
 
 // This is the GetAll method from slides 248-250 (the version with filtering)
-func (c CommentModel) GetAll(content string, author string, filters Filters) ([]*Comment, Metadata, error) {
+// Only comments with the given moderation status are returned.
+func (c CommentModel) GetAll(ctx context.Context, content string, author string, status string, filters Filters) ([]*Comment, Metadata, error) {
 	query := fmt.Sprintf( `
-		SELECT COUNT(*) OVER(),id, created_at, content, author, version
+		SELECT COUNT(*) OVER(),id, created_at, content, author, status, version
 		FROM comments
 		WHERE (to_tsvector('simple', content) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (to_tsvector('simple', author) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		AND status = $3
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout())
 	defer cancel()
 
-	rows, err := c.DB.QueryContext(ctx, query, content, author, filters.limit(), filters.offset())
-	if err != nil {
-		return nil, Metadata{}, err
-	}
-	defer rows.Close()
+	ctx, span := startSpan(ctx, "CommentModel.GetAll")
+	defer span.End()
+
 	totalRecords := 0
 	comments := []*Comment{}
 
-	for rows.Next() {
-		var comment Comment
-		err := rows.Scan(
-			&totalRecords,
-			&comment.ID,
-			&comment.CreatedAt,
-			&comment.Content,
-			&comment.Author,
-			&comment.Version,
-		)
+	err := c.read(ctx, span, func(db *sql.DB) error {
+		// start again if this is a retry on the primary
+		totalRecords, comments = 0, []*Comment{}
+
+		rows, err := db.QueryContext(ctx, query, content, author, status, filters.limit(), filters.offset())
 		if err != nil {
-			return nil, Metadata{}, err
+			return err
 		}
-		comments = append(comments, &comment)
-	}
+		defer rows.Close()
 
-	if err = rows.Err(); err != nil {
+		for rows.Next() {
+			var comment Comment
+			err := rows.Scan(
+				&totalRecords,
+				&comment.ID,
+				&comment.CreatedAt,
+				&comment.Content,
+				&comment.Author,
+				&comment.Status,
+				&comment.Version,
+			)
+			if err != nil {
+				return err
+			}
+			comments = append(comments, &comment)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		span.RecordError(err)
 		return nil, Metadata{}, err
 	}
 
@@ -0,0 +1,206 @@
+// Filename: internal/data/comments_cache.go
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/mickali02/qod/internal/cache"
+)
+
+// listGenerationKey holds a random value that is part of every list
+// key. Changing it invalidates every cached list at once, which works
+// for external stores too since it needs nothing but Get and Set.
+const listGenerationKey = "comments:list:generation"
+
+// CachedCommentStore puts a cache in front of another CommentStore's Get
+// and GetAll. Any write removes the comment's entry and every cached
+// list, since the write may change which comments a list holds. A read
+// racing a write can still cache the old value, so TTL bounds how stale
+// an entry can get.
+type CachedCommentStore struct {
+	Store CommentStore
+	Cache cache.Store
+	TTL   time.Duration
+	// Observe, if set, is told about every lookup: kind is "get" or
+	// "list" and result is "hit" or "miss"
+	Observe func(kind string, result string)
+	// OnError, if set, is told when the cache fails. Cache errors never
+	// fail a request: we just go to the store.
+	OnError func(err error)
+}
+
+var _ CommentStore = (*CachedCommentStore)(nil)
+
+// cachedList is what we keep for one page of GetAll
+type cachedList struct {
+	Comments []*Comment
+	Metadata Metadata
+}
+
+func (c *CachedCommentStore) Insert(ctx context.Context, comment *Comment) error {
+	err := c.Store.Insert(ctx, comment)
+	if err != nil {
+		return err
+	}
+	c.invalidate(ctx, 0)
+	return nil
+}
+
+func (c *CachedCommentStore) Get(ctx context.Context, id int64) (*Comment, error) {
+	key := fmt.Sprintf("comments:item:%d", id)
+
+	// A request that must see its own writes skips the cache, just as
+	// it skips the replicas
+	var comment Comment
+	if !usePrimary(ctx) && c.lookup(ctx, "get", key, &comment) {
+		return &comment, nil
+	}
+
+	found, err := c.Store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.store(ctx, key, found)
+	return found, nil
+}
+
+func (c *CachedCommentStore) Update(ctx context.Context, comment *Comment) error {
+	err := c.Store.Update(ctx, comment)
+	// Invalidate even on failure: we can't tell if the write happened
+	c.invalidate(ctx, comment.ID)
+	return err
+}
+
+func (c *CachedCommentStore) Delete(ctx context.Context, id int64) error {
+	err := c.Store.Delete(ctx, id)
+	c.invalidate(ctx, id)
+	return err
+}
+
+func (c *CachedCommentStore) UpdateStatus(ctx context.Context, id int64, status string) error {
+	err := c.Store.UpdateStatus(ctx, id, status)
+	c.invalidate(ctx, id)
+	return err
+}
+
+func (c *CachedCommentStore) GetAll(ctx context.Context, content string, author string, status string, filters Filters) ([]*Comment, Metadata, error) {
+	generation := c.listGeneration(ctx)
+	key := fmt.Sprintf("comments:list:%s:content=%s&author=%s&status=%s&page=%d&page_size=%d&sort=%s",
+		generation, url.QueryEscape(content), url.QueryEscape(author), url.QueryEscape(status),
+		filters.Page, filters.PageSize, url.QueryEscape(filters.Sort))
+
+	var list cachedList
+	if generation != "" && !usePrimary(ctx) && c.lookup(ctx, "list", key, &list) {
+		return list.Comments, list.Metadata, nil
+	}
+
+	comments, metadata, err := c.Store.GetAll(ctx, content, author, status, filters)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if generation != "" {
+		c.store(ctx, key, cachedList{Comments: comments, Metadata: metadata})
+	}
+	return comments, metadata, nil
+}
+
+// lookup decodes the cached value for key into dst. It returns false on
+// a miss.
+func (c *CachedCommentStore) lookup(ctx context.Context, kind string, key string, dst any) bool {
+	value, found, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		c.reportError(err)
+		found = false
+	}
+	if found {
+		err = gob.NewDecoder(bytes.NewReader(value)).Decode(dst)
+		if err != nil {
+			c.reportError(err)
+			found = false
+		}
+	}
+
+	if c.Observe != nil {
+		result := "miss"
+		if found {
+			result = "hit"
+		}
+		c.Observe(kind, result)
+	}
+	return found
+}
+
+func (c *CachedCommentStore) store(ctx context.Context, key string, value any) {
+	// gob keeps CreatedAt, which JSON would drop (its tag is "-")
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	if err == nil {
+		err = c.Cache.Set(ctx, key, buf.Bytes(), c.TTL)
+	}
+	if err != nil {
+		c.reportError(err)
+	}
+}
+
+// Invalidate removes a comment and every cached list. Use it when the
+// comment was changed by another API instance.
+func (c *CachedCommentStore) Invalidate(ctx context.Context, id int64) {
+	c.invalidate(ctx, id)
+}
+
+// invalidate removes a comment (if id isn't 0) and every cached list
+func (c *CachedCommentStore) invalidate(ctx context.Context, id int64) {
+	// Don't let a cancelled request leave stale entries behind
+	ctx = context.WithoutCancel(ctx)
+
+	if id > 0 {
+		err := c.Cache.Delete(ctx, fmt.Sprintf("comments:item:%d", id))
+		if err != nil {
+			c.reportError(err)
+		}
+	}
+	err := c.Cache.Set(ctx, listGenerationKey, []byte(newGeneration()), 0)
+	if err != nil {
+		c.reportError(err)
+	}
+}
+
+// listGeneration returns the current list generation, starting a new one
+// if there isn't one. It returns "" if the cache can't be used.
+func (c *CachedCommentStore) listGeneration(ctx context.Context) string {
+	value, found, err := c.Cache.Get(ctx, listGenerationKey)
+	if err != nil {
+		c.reportError(err)
+		return ""
+	}
+	if found {
+		return string(value)
+	}
+
+	generation := newGeneration()
+	err = c.Cache.Set(ctx, listGenerationKey, []byte(generation), 0)
+	if err != nil {
+		c.reportError(err)
+		return ""
+	}
+	return generation
+}
+
+func (c *CachedCommentStore) reportError(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+func newGeneration() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,240 @@
+// Filename: internal/data/comments_cache_test.go
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCache is a cache.Store with a clock the test moves by hand
+type fakeCache struct {
+	mu      sync.Mutex
+	now     time.Time
+	entries map[string]fakeEntry
+}
+
+type fakeEntry struct {
+	value     []byte
+	expiresAt time.Time // zero means never
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{
+		now:     time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		entries: make(map[string]fakeEntry),
+	}
+}
+
+func (f *fakeCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e, found := f.entries[key]
+	if !found || (!e.expiresAt.IsZero() && f.now.After(e.expiresAt)) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (f *fakeCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e := fakeEntry{value: value}
+	if ttl > 0 {
+		e.expiresAt = f.now.Add(ttl)
+	}
+	f.entries[key] = e
+	return nil
+}
+
+func (f *fakeCache) Delete(ctx context.Context, keys ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, key := range keys {
+		delete(f.entries, key)
+	}
+	return nil
+}
+
+func (f *fakeCache) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// newTestCachedStore returns a CachedCommentStore in front of an
+// in-memory store, and a function returning the lookups since it was
+// last called ("get:hit", "list:miss", ...)
+func newTestCachedStore(t *testing.T) (*CachedCommentStore, *fakeCache, func() []string) {
+	t.Helper()
+
+	var lookups []string
+	fake := newFakeCache()
+	cached := &CachedCommentStore{
+		Store: NewMemoryCommentStore(),
+		Cache: fake,
+		TTL:   30 * time.Second,
+		Observe: func(kind string, result string) {
+			lookups = append(lookups, kind+":"+result)
+		},
+		OnError: func(err error) { t.Error(err) },
+	}
+	seen := func() []string {
+		got := lookups
+		lookups = nil
+		return got
+	}
+	return cached, fake, seen
+}
+
+func TestCachedCommentStoreGet(t *testing.T) {
+	ctx := context.Background()
+	cached, fake, seen := newTestCachedStore(t)
+
+	comment := &Comment{Content: "hello", Author: "Sam", Status: StatusPublished}
+	err := cached.Insert(ctx, comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(want string) {
+		t.Helper()
+		found, err := cached.Get(ctx, comment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Content != want {
+			t.Errorf("content = %q; want %q", found.Content, want)
+		}
+	}
+
+	get("hello")
+	get("hello")
+	if got := strings.Join(seen(), " "); got != "get:miss get:hit" {
+		t.Errorf("lookups = %s", got)
+	}
+
+	// A write drops the cached comment
+	comment.Content = "hello again"
+	err = cached.Update(ctx, comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get("hello again")
+	if got := strings.Join(seen(), " "); got != "get:miss" {
+		t.Errorf("after Update, lookups = %s", got)
+	}
+
+	// and so does running out of time
+	fake.advance(cached.TTL + time.Second)
+	get("hello again")
+	if got := strings.Join(seen(), " "); got != "get:miss" {
+		t.Errorf("after the TTL, lookups = %s", got)
+	}
+
+	// A request that must see its own writes doesn't use the cache
+	_, err = cached.Get(WithPrimary(ctx), comment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seen(); len(got) != 0 {
+		t.Errorf("WithPrimary looked in the cache: %q", got)
+	}
+
+	err = cached.Delete(ctx, comment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cached.Get(ctx, comment.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Get after Delete: error %v", err)
+	}
+}
+
+func TestCachedCommentStoreLists(t *testing.T) {
+	ctx := context.Background()
+	cached, fake, seen := newTestCachedStore(t)
+	filters := Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: commentSortSafeList}
+
+	list := func() int {
+		t.Helper()
+		comments, _, err := cached.GetAll(ctx, "", "", StatusPublished, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(comments)
+	}
+
+	first := &Comment{Content: "first", Author: "Sam", Status: StatusPublished}
+	err := cached.Insert(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list()
+	list()
+	if got := strings.Join(seen(), " "); got != "list:miss list:hit" {
+		t.Errorf("lookups = %s", got)
+	}
+
+	// Each page is cached on its own
+	filters.Page = 2
+	list()
+	filters.Page = 1
+	if got := strings.Join(seen(), " "); got != "list:miss" {
+		t.Errorf("page 2: lookups = %s", got)
+	}
+
+	// Any write starts a new generation, so no list is served again
+	err = cached.Insert(ctx, &Comment{Content: "second", Author: "Sam", Status: StatusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := list(); n != 2 {
+		t.Errorf("after Insert, got %d comments; want 2", n)
+	}
+	err = cached.UpdateStatus(ctx, first.ID, StatusPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := list(); n != 1 {
+		t.Errorf("after UpdateStatus, got %d comments; want 1", n)
+	}
+	if got := strings.Join(seen(), " "); got != "list:miss list:miss" {
+		t.Errorf("after writes, lookups = %s", got)
+	}
+
+	// A change made by another instance does the same
+	list()
+	cached.Invalidate(ctx, first.ID)
+	list()
+	if got := strings.Join(seen(), " "); got != "list:hit list:miss" {
+		t.Errorf("after Invalidate, lookups = %s", got)
+	}
+
+	// The generation itself never expires, but the lists do
+	fake.advance(cached.TTL + time.Second)
+	if _, found, _ := fake.Get(ctx, listGenerationKey); !found {
+		t.Error("the list generation expired")
+	}
+	list()
+	if got := strings.Join(seen(), " "); got != "list:miss" {
+		t.Errorf("after the TTL, lookups = %s", got)
+	}
+
+	// If the generation is lost (the cache was cleared), lists start over
+	err = fake.Delete(ctx, listGenerationKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list()
+	list()
+	if got := strings.Join(seen(), " "); got != "list:miss list:hit" {
+		t.Errorf("after losing the generation, lookups = %s", got)
+	}
+}
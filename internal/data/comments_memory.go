@@ -0,0 +1,207 @@
+// Filename: internal/data/comments_memory.go
+package data
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryCommentStore is a CommentStore which keeps comments in memory.
+// It behaves like CommentModel (including the search, sorting and
+// pagination rules of GetAll) so handlers can be exercised without
+// PostgreSQL.
+type MemoryCommentStore struct {
+	mu       sync.RWMutex
+	nextID   int64
+	comments map[int64]*Comment
+}
+
+// Make sure both stores keep up with the interface
+var (
+	_ CommentStore = CommentModel{}
+	_ CommentStore = (*MemoryCommentStore)(nil)
+)
+
+func NewMemoryCommentStore() *MemoryCommentStore {
+	return &MemoryCommentStore{
+		nextID:   1,
+		comments: make(map[int64]*Comment),
+	}
+}
+
+func (m *MemoryCommentStore) Insert(ctx context.Context, comment *Comment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment.ID = m.nextID
+	m.nextID++
+	// created_at is a timestamp(0), so it has no fractional seconds
+	comment.CreatedAt = time.Now().Truncate(time.Second)
+	comment.Version = 1
+	if comment.Status == "" {
+		comment.Status = StatusPublished
+	}
+
+	stored := *comment
+	m.comments[comment.ID] = &stored
+	return nil
+}
+
+func (m *MemoryCommentStore) Get(ctx context.Context, id int64) (*Comment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, found := m.comments[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	comment := *stored
+	return &comment, nil
+}
+
+func (m *MemoryCommentStore) Update(ctx context.Context, comment *Comment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.comments[comment.ID]
+	if !found {
+		// UPDATE ... RETURNING finds no row to return
+		return sql.ErrNoRows
+	}
+
+	stored.Content = comment.Content
+	stored.Author = comment.Author
+	stored.Status = comment.Status
+	stored.Version++
+	comment.Version = stored.Version
+	return nil
+}
+
+func (m *MemoryCommentStore) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.comments[id]; !found {
+		return ErrRecordNotFound
+	}
+	delete(m.comments, id)
+	return nil
+}
+
+func (m *MemoryCommentStore) UpdateStatus(ctx context.Context, id int64, status string) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.comments[id]
+	if !found {
+		return ErrRecordNotFound
+	}
+	stored.Status = status
+	stored.Version++
+	return nil
+}
+
+func (m *MemoryCommentStore) GetAll(ctx context.Context, content string, author string, status string, filters Filters) ([]*Comment, Metadata, error) {
+	// Check the sort first, just as the SQL version does
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	m.mu.RLock()
+	matches := []*Comment{}
+	for _, stored := range m.comments {
+		if stored.Status != status {
+			continue
+		}
+		if !textMatches(stored.Content, content) || !textMatches(stored.Author, author) {
+			continue
+		}
+		comment := *stored
+		matches = append(matches, &comment)
+	}
+	m.mu.RUnlock()
+
+	// ORDER BY <column> <direction>, id ASC
+	slices.SortFunc(matches, func(x, y *Comment) int {
+		var result int
+		switch column {
+		case "author":
+			result = strings.Compare(x.Author, y.Author)
+		case "content":
+			result = strings.Compare(x.Content, y.Content)
+		case "created_at":
+			result = x.CreatedAt.Compare(y.CreatedAt)
+		default:
+			result = cmp.Compare(x.ID, y.ID)
+		}
+		if direction == "DESC" {
+			result = -result
+		}
+		if result == 0 {
+			result = cmp.Compare(x.ID, y.ID)
+		}
+		return result
+	})
+
+	// LIMIT/OFFSET. COUNT(*) OVER() comes back with the rows, so a page
+	// past the end has no rows and therefore no total either.
+	start := min(filters.offset(), len(matches))
+	end := min(start+filters.limit(), len(matches))
+	page := matches[start:end]
+
+	totalRecords := 0
+	if len(page) > 0 {
+		totalRecords = len(matches)
+	}
+
+	return page, calculateMetaData(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// MatchesSearch reports whether a comment would be returned by GetAll's
+// content and author search
+func MatchesSearch(content string, author string, contentQuery string, authorQuery string) bool {
+	return textMatches(content, contentQuery) && textMatches(author, authorQuery)
+}
+
+// textMatches mimics
+//
+//	to_tsvector('simple', text) @@ plainto_tsquery('simple', query) OR query = ''
+//
+// every word in the query must appear as a word in the text, ignoring case
+func textMatches(text string, query string) bool {
+	if query == "" {
+		return true
+	}
+
+	words := tsWords(text)
+	for _, word := range tsWords(query) {
+		if !slices.Contains(words, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// tsWords splits text into lower case words the way the 'simple' text
+// search configuration does
+func tsWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,231 @@
+// Filename: internal/data/comments_memory_test.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// seedComments are stored in this order, so their IDs are 1, 2, 3, ...
+// The authors are all capitalised because how PostgreSQL orders "adam"
+// and "Bob" depends on the database's collation.
+var seedComments = []Comment{
+	{Content: "The quick brown fox", Author: "Zoe", Status: StatusPublished},                // 1
+	{Content: "A lazy dog sleeps", Author: "Adam", Status: StatusPublished},                 // 2
+	{Content: "Quick thinking wins", Author: "Mia Quick", Status: StatusPublished},          // 3
+	{Content: "Held for review, quick", Author: "Zoe", Status: StatusPending},               // 4
+	{Content: "Brown bread is best", Author: "Bob", Status: StatusPublished},                // 5
+	{Content: "Rejected spam", Author: "Spammer", Status: StatusRejected},                   // 6
+	{Content: "the fox, the dog and the FOX again", Author: "Zoe", Status: StatusPublished}, // 7
+}
+
+var commentSortSafeList = []string{"id", "author", "-id", "-author"}
+
+// getAllTests are GetAll calls and the comment IDs that PostgreSQL
+// returns for them
+var getAllTests = []struct {
+	name     string
+	content  string
+	author   string
+	status   string
+	sort     string
+	page     int
+	pageSize int
+	wantIDs  []int64
+	wantMeta Metadata
+}{
+	{
+		name: "everything published", status: StatusPublished, sort: "id", page: 1, pageSize: 10,
+		wantIDs:  []int64{1, 2, 3, 5, 7},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+	},
+	{
+		name: "newest first", status: StatusPublished, sort: "-id", page: 1, pageSize: 10,
+		wantIDs:  []int64{7, 5, 3, 2, 1},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+	},
+	{
+		// ties on author are broken by id ascending, whatever the direction
+		name: "by author", status: StatusPublished, sort: "author", page: 1, pageSize: 10,
+		wantIDs:  []int64{2, 5, 3, 1, 7},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+	},
+	{
+		name: "by author descending", status: StatusPublished, sort: "-author", page: 1, pageSize: 10,
+		wantIDs:  []int64{1, 7, 3, 5, 2},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+	},
+	{
+		name: "content search is by word and ignores case", content: "QUICK", status: StatusPublished, sort: "id", page: 1, pageSize: 10,
+		wantIDs:  []int64{1, 3},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 2},
+	},
+	{
+		name: "every word must match", content: "brown fox", status: StatusPublished, sort: "id", page: 1, pageSize: 10,
+		wantIDs:  []int64{1},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+	},
+	{
+		name: "punctuation splits words", content: "fox dog", status: StatusPublished, sort: "id", page: 1, pageSize: 10,
+		wantIDs:  []int64{7},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+	},
+	{
+		name: "author search", author: "zoe", status: StatusPublished, sort: "id", page: 1, pageSize: 10,
+		wantIDs:  []int64{1, 7},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 2},
+	},
+	{
+		name: "content and author together", content: "quick", author: "mia", status: StatusPublished, sort: "id", page: 1, pageSize: 10,
+		wantIDs:  []int64{3},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+	},
+	{
+		name: "part of a word does not match", content: "qui", status: StatusPublished, sort: "id", page: 1, pageSize: 10,
+		wantIDs:  []int64{},
+		wantMeta: Metadata{},
+	},
+	{
+		name: "other statuses", status: StatusPending, sort: "id", page: 1, pageSize: 10,
+		wantIDs:  []int64{4},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+	},
+	{
+		name: "first page", status: StatusPublished, sort: "id", page: 1, pageSize: 2,
+		wantIDs:  []int64{1, 2},
+		wantMeta: Metadata{CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5},
+	},
+	{
+		name: "last page is short", status: StatusPublished, sort: "id", page: 3, pageSize: 2,
+		wantIDs:  []int64{7},
+		wantMeta: Metadata{CurrentPage: 3, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5},
+	},
+	{
+		// COUNT(*) OVER() comes back with the rows, so there is no total
+		name: "page past the end", status: StatusPublished, sort: "id", page: 4, pageSize: 2,
+		wantIDs:  []int64{},
+		wantMeta: Metadata{},
+	},
+}
+
+// seed stores seedComments in store
+func seed(t *testing.T, store CommentStore) {
+	t.Helper()
+
+	for _, c := range seedComments {
+		comment := c
+		err := store.Insert(context.Background(), &comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// runGetAllTests checks a store against getAllTests
+func runGetAllTests(t *testing.T, store CommentStore) {
+	for _, tt := range getAllTests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := Filters{Page: tt.page, PageSize: tt.pageSize, Sort: tt.sort, SortSafeList: commentSortSafeList}
+
+			comments, metadata, err := store.GetAll(context.Background(), tt.content, tt.author, tt.status, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int64{}
+			for _, comment := range comments {
+				ids = append(ids, comment.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("got IDs %v; want %v", ids, tt.wantIDs)
+			}
+			if metadata != tt.wantMeta {
+				t.Errorf("got metadata %+v; want %+v", metadata, tt.wantMeta)
+			}
+		})
+	}
+}
+
+func TestMemoryCommentStoreGetAll(t *testing.T) {
+	store := NewMemoryCommentStore()
+	seed(t, store)
+	runGetAllTests(t, store)
+}
+
+// TestCommentModelGetAll runs the same cases against PostgreSQL, to keep
+// MemoryCommentStore honest. It needs a database (see openTestDB).
+func TestCommentModelGetAll(t *testing.T) {
+	db := openTestDB(t)
+
+	store := CommentModel{DB: db}
+	seed(t, store)
+	runGetAllTests(t, store)
+}
+
+func TestMemoryCommentStoreCRUD(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCommentStore()
+
+	comment := &Comment{Content: "hello", Author: "sam"}
+	err := store.Insert(ctx, comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.ID != 1 || comment.Version != 1 || comment.Status != StatusPublished {
+		t.Fatalf("after Insert got %+v", comment)
+	}
+
+	// Changing what Get returned doesn't change the stored comment
+	got, err := store.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Content = "changed"
+	again, _ := store.Get(ctx, 1)
+	if again.Content != "hello" {
+		t.Error("Get returned the stored comment rather than a copy")
+	}
+
+	got.Author = "alex"
+	err = store.Update(ctx, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 {
+		t.Errorf("version after Update = %d; want 2", got.Version)
+	}
+
+	err = store.UpdateStatus(ctx, 1, StatusPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = store.Get(ctx, 1)
+	if got.Status != StatusPending || got.Version != 3 || got.Author != "alex" {
+		t.Errorf("after UpdateStatus got %+v", got)
+	}
+
+	err = store.Delete(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing comments behave as they do in PostgreSQL
+	if _, err := store.Get(ctx, 1); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Get after Delete: %v", err)
+	}
+	if err := store.Delete(ctx, 1); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("second Delete: %v", err)
+	}
+	if err := store.UpdateStatus(ctx, 1, StatusPublished); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("UpdateStatus after Delete: %v", err)
+	}
+	if err := store.Update(ctx, got); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Update after Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, 0); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Get(0): %v", err)
+	}
+}
@@ -2,8 +2,24 @@
 package data 
 
 import (
+    "context"
     "errors"
+
+    "github.com/lib/pq"
 )
 
 var ErrRecordNotFound = errors.New("record not found")
 
+var ErrDuplicateReport = errors.New("duplicate report")
+
+var ErrEditConflict = errors.New("edit conflict")
+
+// IsTimeout reports whether a query failed because it ran out of time,
+// either because its context expired or because PostgreSQL cancelled it
+func IsTimeout(err error) bool {
+    if errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    var pqErr *pq.Error
+    return errors.As(err, &pqErr) && pqErr.Code == "57014" // query_canceled
+}
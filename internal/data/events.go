@@ -0,0 +1,210 @@
+// Filename: internal/data/events.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/mickali02/qod/internal/events"
+)
+
+// eventLogLock is the advisory lock held from writing an event until its
+// transaction ends; see recordEvent
+const eventLogLock = 4_411_000_044
+
+// recordEvent adds a change to the comment_events log and the webhook
+// outbox, and tells every API instance listening on events.Channel about
+// it. All of this only happens if tx commits.
+//
+// Feed clients catch up with "every event after the last ID I saw", so
+// IDs have to become visible in order. A sequence doesn't promise that:
+// a transaction that took ID 5 can commit after one that took ID 6, and
+// a client that saw 6 would never get 5. Holding eventLogLock until the
+// transaction ends means the next ID is only handed out once the last
+// one has committed (or rolled back). Writers of comments queue up
+// behind each other from here on, so this should be the last thing a
+// transaction does.
+func recordEvent(ctx context.Context, tx *sql.Tx, event events.Event) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(eventLogLock))
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO comment_events (type, comment_id, content, author, status, previous_status, version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+
+	args := []any{event.Type, event.CommentID, event.Content, event.Author, event.Status, event.PreviousStatus, event.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.At)
+	if err != nil {
+		return err
+	}
+
+	err = recordWebhookEvent(ctx, tx, event)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, events.Channel, string(payload))
+	return err
+}
+
+// PublicChange returns the type of change someone who can only see
+// published comments would notice: a comment being published looks like
+// it was created and one being hidden looks like it was deleted. Changes
+// to comments nobody could see aren't noticed at all, so ok is false.
+// An update without a previous status is taken to be a change to a
+// published comment.
+func PublicChange(event events.Event) (eventType string, ok bool) {
+	published := event.Status == StatusPublished
+	wasPublished := event.PreviousStatus == "" || event.PreviousStatus == StatusPublished
+
+	switch {
+	case event.Type == events.CommentCreated && published:
+		return events.CommentCreated, true
+	case event.Type == events.CommentUpdated && published && wasPublished:
+		return events.CommentUpdated, true
+	case event.Type == events.CommentUpdated && published:
+		return events.CommentCreated, true
+	case event.Type == events.CommentUpdated && wasPublished:
+		return events.CommentDeleted, true
+	case event.Type == events.CommentDeleted && published:
+		return events.CommentDeleted, true
+	default:
+		return "", false
+	}
+}
+
+// recordWebhookEvent adds a change to the webhook outbox if a webhook
+// wants it. The dispatcher turns it into deliveries once tx commits. Webhooks are public, so they
+// are told about changes the way PublicChange sees them.
+func recordWebhookEvent(ctx context.Context, tx *sql.Tx, event events.Event) error {
+	eventType, ok := PublicChange(event)
+	if !ok {
+		return nil
+	}
+
+	comment := &Comment{
+		ID:      event.CommentID,
+		Content: event.Content,
+		Author:  event.Author,
+		Status:  event.Status,
+		Version: event.Version,
+	}
+	// A hidden comment's content is what is waiting for a moderator
+	if event.Type == events.CommentUpdated && eventType == events.CommentDeleted {
+		comment.Content = ""
+		comment.Author = ""
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"type":        eventType,
+		"event_id":    event.ID,
+		"occurred_at": event.At,
+		"comment":     comment,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Nothing is written if no active webhook wants the event, so the
+	// outbox stays empty until someone registers one
+	query := `
+		INSERT INTO webhook_outbox (event_type, payload)
+		SELECT $1::text, $2::jsonb
+		WHERE EXISTS (
+			SELECT 1 FROM webhooks
+			WHERE active AND (cardinality(events) = 0 OR $1::text = ANY(events))
+		)`
+
+	_, err = tx.ExecContext(ctx, query, eventType, payload)
+	return err
+}
+
+// EventModel reads the comment_events log
+type EventModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration // how long a single query may run (default 3s)
+}
+
+// queryTimeout returns the time limit for one query
+func (m EventModel) queryTimeout() time.Duration {
+	if m.QueryTimeout > 0 {
+		return m.QueryTimeout
+	}
+	return 3 * time.Second
+}
+
+// Since returns up to limit events which came after the event with ID
+// afterID, oldest first. IDs become visible in order (see recordEvent),
+// so nothing before afterID can turn up later.
+func (m EventModel) Since(ctx context.Context, afterID int64, limit int) ([]events.Event, error) {
+	query := `
+		SELECT id, created_at, type, comment_id, content, author, status, previous_status, version
+		FROM comment_events
+		WHERE id > $1
+		ORDER BY id ASC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	ctx, span := startSpan(ctx, "EventModel.Since")
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := []events.Event{}
+	for rows.Next() {
+		var event events.Event
+		err := rows.Scan(&event.ID, &event.At, &event.Type, &event.CommentID,
+			&event.Content, &event.Author, &event.Status, &event.PreviousStatus, &event.Version)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		found = append(found, event)
+	}
+
+	err = rows.Err()
+	span.RecordError(err)
+	return found, err
+}
+
+// LatestID returns the ID of the newest event, or 0 if there are none
+func (m EventModel) LatestID(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM comment_events`).Scan(&id)
+	return id, err
+}
+
+// Prune deletes events older than maxAge and returns how many went
+func (m EventModel) Prune(ctx context.Context, maxAge time.Duration) (int64, error) {
+	query := `
+		DELETE FROM comment_events
+		WHERE created_at < NOW() - make_interval(secs => $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, maxAge.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,298 @@
+// Filename: internal/data/events_test.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mickali02/qod/internal/events"
+	"github.com/mickali02/qod/internal/webhooks"
+)
+
+func TestPublicChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		status    string
+		previous  string
+		want      string // "" means nobody notices
+	}{
+		{"published comment created", events.CommentCreated, StatusPublished, "", events.CommentCreated},
+		{"held comment created", events.CommentCreated, StatusPending, "", ""},
+		{"published comment edited", events.CommentUpdated, StatusPublished, StatusPublished, events.CommentUpdated},
+		{"held comment approved", events.CommentUpdated, StatusPublished, StatusPending, events.CommentCreated},
+		{"rejected comment approved", events.CommentUpdated, StatusPublished, StatusRejected, events.CommentCreated},
+		{"published comment hidden", events.CommentUpdated, StatusPending, StatusPublished, events.CommentDeleted},
+		{"published comment rejected", events.CommentUpdated, StatusRejected, StatusPublished, events.CommentDeleted},
+		{"held comment rejected", events.CommentUpdated, StatusRejected, StatusPending, ""},
+		{"held comment edited", events.CommentUpdated, StatusPending, StatusPending, ""},
+		{"update without a previous status", events.CommentUpdated, StatusPublished, "", events.CommentUpdated},
+		{"hide without a previous status", events.CommentUpdated, StatusPending, "", events.CommentDeleted},
+		{"published comment deleted", events.CommentDeleted, StatusPublished, "", events.CommentDeleted},
+		{"held comment deleted", events.CommentDeleted, StatusPending, "", ""},
+		{"resync", events.Resync, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := PublicChange(events.Event{Type: tt.eventType, Status: tt.status, PreviousStatus: tt.previous})
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("got %q, %v; want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+// outboxEvent is a row of webhook_outbox
+type outboxEvent struct {
+	Type    string `json:"type"`
+	Comment struct {
+		ID      int64  `json:"id"`
+		Content string `json:"content"`
+		Status  string `json:"status"`
+	} `json:"comment"`
+}
+
+// readOutbox returns every event in the webhook outbox, oldest first
+func readOutbox(t *testing.T, db *sql.DB) []outboxEvent {
+	t.Helper()
+
+	rows, err := db.QueryContext(t.Context(), `SELECT event_type, payload FROM webhook_outbox ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var found []outboxEvent
+	for rows.Next() {
+		var (
+			eventType string
+			payload   []byte
+			event     outboxEvent
+		)
+		err = rows.Scan(&eventType, &payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.Unmarshal(payload, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != eventType {
+			t.Errorf("outbox event_type %q but the payload says %q", eventType, event.Type)
+		}
+		found = append(found, event)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+// TestWebhookOutbox checks that only published comments a webhook wants
+// reach the outbox and that the outbox is cleared out. It needs a
+// database (see openTestDB).
+func TestWebhookOutbox(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	comments := CommentModel{DB: db}
+	hooks := WebhookModel{DB: db}
+
+	// Nothing is written while no webhook wants the event
+	err := comments.Insert(ctx, &Comment{Content: "nobody is listening", Author: "Sam", Status: StatusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletesOnly := &Webhook{URL: "https://example.com/deletes", Secret: "secret", Events: []string{events.CommentDeleted}, Active: true}
+	err = hooks.Insert(ctx, deletesOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = comments.Insert(ctx, &Comment{Content: "only deletes are wanted", Author: "Sam", Status: StatusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readOutbox(t, db); len(got) != 0 {
+		t.Fatalf("got %d outbox events nobody wanted: %+v", len(got), got)
+	}
+
+	everything := &Webhook{URL: "https://example.com/hooks", Secret: "secret", Events: []string{}, Active: true}
+	err = hooks.Insert(ctx, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := &Comment{Content: "held for review", Author: "Sam", Status: StatusPending}
+	published := &Comment{Content: "hello", Author: "Sam", Status: StatusPublished}
+	for _, comment := range []*Comment{held, published} {
+		err := comments.Insert(ctx, comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []func() error{
+		func() error { held.Content = "still held"; return comments.Update(ctx, held) },
+		func() error { return comments.UpdateStatus(ctx, held.ID, StatusRejected) },
+		func() error { return comments.Delete(ctx, held.ID) },
+		func() error { published.Content = "hello again"; return comments.Update(ctx, published) },
+		func() error { return comments.UpdateStatus(ctx, published.ID, StatusPending) },
+		func() error { return comments.UpdateStatus(ctx, published.ID, StatusPublished) },
+		func() error { return comments.Delete(ctx, published.ID) },
+	}
+	for _, step := range steps {
+		err := step()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []struct {
+		eventType string
+		content   string
+	}{
+		{events.CommentCreated, "hello"},
+		{events.CommentUpdated, "hello again"},
+		{events.CommentDeleted, ""}, // hidden, so its content isn't sent
+		{events.CommentCreated, "hello again"},
+		{events.CommentDeleted, "hello again"},
+	}
+	got := readOutbox(t, db)
+	if len(got) != len(want) {
+		t.Fatalf("got %d outbox events (%+v); want %d", len(got), got, len(want))
+	}
+	for i, event := range got {
+		if event.Type != want[i].eventType || event.Comment.Content != want[i].content || event.Comment.ID != published.ID {
+			t.Errorf("event %d is %+v; want %s of comment %d with content %q",
+				i, event, want[i].eventType, published.ID, want[i].content)
+		}
+	}
+
+	// If the webhooks are switched off before the events are fanned out,
+	// nothing needs them any more
+	_, err = db.ExecContext(ctx, `UPDATE webhooks SET active = false`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled, err := hooks.FanOut(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled != len(want) {
+		t.Errorf("FanOut handled %d events; want %d", handled, len(want))
+	}
+	if got := readOutbox(t, db); len(got) != 0 {
+		t.Errorf("%d events nobody wanted are still in the outbox", len(got))
+	}
+
+	// An event with a delivery is kept until the delivery is done
+	_, err = db.ExecContext(ctx, `UPDATE webhooks SET active = true WHERE id = $1`, everything.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = comments.Insert(ctx, &Comment{Content: "for the webhook", Author: "Sam", Status: StatusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = hooks.FanOut(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := hooks.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("claimed %d deliveries; want 1", len(deliveries))
+	}
+
+	pruned, err := hooks.Prune(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 0 {
+		t.Errorf("Prune deleted %d events with a pending delivery", pruned)
+	}
+
+	err = hooks.Record(ctx, webhooks.Attempt{DeliveryID: deliveries[0].ID, Attempt: 1, StatusCode: 200, Succeeded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pruned, err = hooks.Prune(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 0 {
+		t.Errorf("Prune deleted %d events newer than maxAge", pruned)
+	}
+	pruned, err = hooks.Prune(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Errorf("Prune deleted %d events; want 1", pruned)
+	}
+}
+
+// TestEventIDsFollowCommitOrder checks that an event can't become visible
+// before one with a lower ID. It needs a database (see openTestDB).
+func TestEventIDsFollowCommitOrder(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	model := EventModel{DB: db}
+
+	first, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+	err = recordEvent(ctx, first, events.Event{Type: events.CommentCreated, CommentID: 1, Status: StatusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second writer has to wait for the first to commit...
+	done := make(chan error, 1)
+	go func() {
+		second, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer second.Rollback()
+		err = recordEvent(ctx, second, events.Event{Type: events.CommentCreated, CommentID: 2, Status: StatusPublished})
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- second.Commit()
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("the second event was written while the first was open (err %v)", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// ...so once it has, the events are read back in commit order
+	err = first.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := model.Since(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].CommentID != 1 || found[1].CommentID != 2 || found[0].ID >= found[1].ID {
+		t.Errorf("got events %+v", found)
+	}
+}
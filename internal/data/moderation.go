@@ -0,0 +1,143 @@
+// Filename: internal/data/moderation.go
+package data
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// The statuses a comment can be in. Only published comments are visible
+// to the public; pending comments wait in the moderation queue.
+const (
+	StatusPublished = "published"
+	StatusPending   = "pending"
+	StatusRejected  = "rejected"
+)
+
+// The actions a Moderator can take. They are ordered by severity so that
+// when several rules run, the most severe one wins.
+type Action int
+
+const (
+	ActionApprove Action = iota
+	ActionHold
+	ActionReject
+)
+
+// Decision is the outcome of moderating a comment
+type Decision struct {
+	Action Action
+	Reason string
+}
+
+// Status returns the comment status that matches the decision
+func (d Decision) Status() string {
+	switch d.Action {
+	case ActionHold:
+		return StatusPending
+	case ActionReject:
+		return StatusRejected
+	default:
+		return StatusPublished
+	}
+}
+
+// Moderator is anything that can look at a comment before it is saved
+// and decide whether to publish it, hold it for review or reject it.
+type Moderator interface {
+	Moderate(comment *Comment) Decision
+}
+
+// ModeratorChain runs each moderator in order and keeps the most
+// severe decision.
+type ModeratorChain []Moderator
+
+func (m ModeratorChain) Moderate(comment *Comment) Decision {
+	decision := Decision{Action: ActionApprove}
+	for _, moderator := range m {
+		d := moderator.Moderate(comment)
+		if d.Action > decision.Action {
+			decision = d
+		}
+	}
+	return decision
+}
+
+// DefaultBlockedWords is the built-in profanity word list
+var DefaultBlockedWords = []string{
+	"arsehole", "asshole", "bastard", "bitch", "bollocks", "bullshit",
+	"cunt", "dickhead", "fuck", "fucker", "fucking", "motherfucker",
+	"shit", "slut", "twat", "wanker", "whore",
+}
+
+// ProfanityRule rejects comments which contain a blocked word
+type ProfanityRule struct {
+	Words []string
+}
+
+func (p ProfanityRule) Moderate(comment *Comment) Decision {
+	text := strings.ToLower(comment.Content + " " + comment.Author)
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if slices.Contains(p.Words, word) {
+			return Decision{Action: ActionReject, Reason: "must not contain offensive language"}
+		}
+	}
+	return Decision{Action: ActionApprove}
+}
+
+var linkRX = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// LinkRule holds comments with more than Max links for review
+type LinkRule struct {
+	Max int
+}
+
+func (l LinkRule) Moderate(comment *Comment) Decision {
+	if len(linkRX.FindAllString(comment.Content, -1)) > l.Max {
+		return Decision{Action: ActionHold, Reason: "contains too many links"}
+	}
+	return Decision{Action: ActionApprove}
+}
+
+// RepeatedCharacterRule holds comments which repeat the same character
+// more than Max times in a row (e.g. "!!!!!!!!" or "soooooooo")
+type RepeatedCharacterRule struct {
+	Max int
+}
+
+func (rc RepeatedCharacterRule) Moderate(comment *Comment) Decision {
+	var previous rune
+	count := 0
+	for _, r := range comment.Content {
+		if r == previous && !unicode.IsSpace(r) {
+			count++
+		} else {
+			previous = r
+			count = 1
+		}
+		if count > rc.Max {
+			return Decision{Action: ActionHold, Reason: "contains too many repeated characters"}
+		}
+	}
+	return Decision{Action: ActionApprove}
+}
+
+// NewDefaultModerator builds the built-in moderation rules. Any extra
+// blocked words are added to the default word list.
+func NewDefaultModerator(extraWords []string, maxLinks int, maxRepeated int) Moderator {
+	words := slices.Clone(DefaultBlockedWords)
+	for _, word := range extraWords {
+		words = append(words, strings.ToLower(word))
+	}
+
+	return ModeratorChain{
+		ProfanityRule{Words: words},
+		LinkRule{Max: maxLinks},
+		RepeatedCharacterRule{Max: maxRepeated},
+	}
+}
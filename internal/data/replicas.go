@@ -0,0 +1,190 @@
+// Filename: internal/data/replicas.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// primaryKey marks a context whose reads must go to the primary
+type primaryKey struct{}
+
+// WithPrimary returns a context whose reads skip the replicas. Use it
+// when a request has to see its own writes straight away, since the
+// replicas may lag behind the primary.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// usePrimary reports whether WithPrimary was called on ctx
+func usePrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// replica is one read-only copy of the database
+type replica struct {
+	name string
+	db   *sql.DB
+
+	healthy atomic.Bool
+	mu      sync.Mutex
+	lastErr error
+	checked time.Time
+}
+
+// ReplicaStatus describes a replica for the health check
+type ReplicaStatus struct {
+	Name        string    `json:"name"`
+	Healthy     bool      `json:"healthy"`
+	Error       string    `json:"error,omitempty"`
+	LastChecked time.Time `json:"last_checked,omitzero"`
+}
+
+// ReplicaSet hands out read replicas in turn, skipping any that have
+// failed. A nil *ReplicaSet has no replicas, so every read goes to the
+// primary.
+type ReplicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+// NewReplicaSet wraps the replica pools. They start out healthy; call
+// Check (or Monitor) to find out otherwise.
+func NewReplicaSet(names []string, dbs []*sql.DB) *ReplicaSet {
+	set := &ReplicaSet{}
+	for i, db := range dbs {
+		r := &replica{name: names[i], db: db}
+		r.healthy.Store(true)
+		set.replicas = append(set.replicas, r)
+	}
+	return set
+}
+
+// pick returns the next healthy replica, or nil if there isn't one
+func (s *ReplicaSet) pick() *replica {
+	if s == nil || len(s.replicas) == 0 {
+		return nil
+	}
+
+	start := s.next.Add(1)
+	for i := range uint64(len(s.replicas)) {
+		r := s.replicas[(start+i)%uint64(len(s.replicas))]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// Check pings every replica and records whether it answered
+func (s *ReplicaSet) Check(ctx context.Context) {
+	if s == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range s.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.record(r.db.PingContext(ctx))
+		}()
+	}
+	wg.Wait()
+}
+
+// Monitor calls Check every interval until ctx is cancelled, so a
+// replica that failed is put back in rotation once it recovers
+func (s *ReplicaSet) Monitor(ctx context.Context, interval time.Duration, timeout time.Duration) {
+	if s == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			s.Check(checkCtx)
+			cancel()
+		}
+	}
+}
+
+// Status reports on every replica
+func (s *ReplicaSet) Status() []ReplicaStatus {
+	if s == nil {
+		return nil
+	}
+
+	statuses := make([]ReplicaStatus, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		r.mu.Lock()
+		status := ReplicaStatus{Name: r.name, Healthy: r.healthy.Load(), LastChecked: r.checked}
+		if r.lastErr != nil {
+			status.Error = r.lastErr.Error()
+		}
+		r.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Close closes every replica pool
+func (s *ReplicaSet) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, r := range s.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (r *replica) record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastErr = err
+	r.checked = time.Now()
+	r.healthy.Store(err == nil)
+}
+
+// isConnectionError reports whether err means the server couldn't be
+// used at all, as opposed to a problem with the query itself
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// Class 08 is connection_exception; 57P01-57P03 are the server
+	// shutting down or not yet accepting connections
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		code := string(pqErr.Code)
+		return strings.HasPrefix(code, "08") || strings.HasPrefix(code, "57P")
+	}
+	return false
+}
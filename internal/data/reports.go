@@ -0,0 +1,156 @@
+// Filename: internal/data/reports.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mickali02/qod/internal/validator"
+)
+
+// ReportReasons are the reasons a reader may give for flagging a comment
+var ReportReasons = []string{"spam", "harassment", "hate_speech", "misinformation", "off_topic", "other"}
+
+// Report is a reader's complaint about a comment
+type Report struct {
+	ID        int64     `json:"id"`
+	CommentID int64     `json:"comment_id"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details,omitempty"`
+	Reporter  string    `json:"-"` // who reported it (used to dedupe), kept private
+	CreatedAt time.Time `json:"created_at"`
+	// ResolvedAt is when a moderator decided on the comment after this
+	// report. Resolved reports no longer count towards hiding it.
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+func ValidateReport(v *validator.Validator, report *Report) {
+	v.Check(validator.PermittedValue(report.Reason, ReportReasons...), "reason", "invalid reason value")
+	v.Check(len(report.Details) <= 500, "details", "must not be more than 500 bytes long")
+}
+
+type ReportModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration // how long a single query may run (default 3s)
+}
+
+// queryTimeout returns the time limit for one query
+func (m ReportModel) queryTimeout() time.Duration {
+	if m.QueryTimeout > 0 {
+		return m.QueryTimeout
+	}
+	return 3 * time.Second
+}
+
+// Insert stores a report. A reporter may only have one open report on
+// a comment.
+func (m ReportModel) Insert(ctx context.Context, report *Report) error {
+	query := `
+		INSERT INTO reports (comment_id, reason, details, reporter)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []any{report.CommentID, report.Reason, report.Details, report.Reporter}
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&report.ID, &report.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505": // unique_violation
+			return ErrDuplicateReport
+		case errors.As(err, &pqErr) && pqErr.Code == "23503": // foreign_key_violation
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// CountForComment returns how many open reports a comment has, that is
+// reports filed since a moderator last decided on it
+func (m ReportModel) CountForComment(ctx context.Context, commentID int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM reports
+		WHERE comment_id = $1
+		AND resolved_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, commentID).Scan(&count)
+	return count, err
+}
+
+// Resolve closes the open reports on a comment. It is called when a
+// moderator approves or rejects the comment.
+func (m ReportModel) Resolve(ctx context.Context, commentID int64) error {
+	query := `
+		UPDATE reports
+		SET resolved_at = NOW()
+		WHERE comment_id = $1
+		AND resolved_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, commentID)
+	return err
+}
+
+// GetAll lists reports, optionally only those for one comment (commentID > 0)
+// and/or with one reason (reason != "")
+func (m ReportModel) GetAll(ctx context.Context, commentID int64, reason string, filters Filters) ([]*Report, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, comment_id, reason, details, reporter, resolved_at
+		FROM reports
+		WHERE (comment_id = $1 OR $1 = 0)
+		AND (reason = $2 OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, commentID, reason, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	reports := []*Report{}
+
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(
+			&totalRecords,
+			&report.ID,
+			&report.CreatedAt,
+			&report.CommentID,
+			&report.Reason,
+			&report.Details,
+			&report.Reporter,
+			&report.ResolvedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reports = append(reports, &report)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return reports, metadata, nil
+}
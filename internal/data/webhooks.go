@@ -0,0 +1,467 @@
+// Filename: internal/data/webhooks.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mickali02/qod/internal/events"
+	"github.com/mickali02/qod/internal/validator"
+	"github.com/mickali02/qod/internal/webhooks"
+)
+
+// WebhookEvents are the events a webhook can subscribe to
+var WebhookEvents = []string{events.CommentCreated, events.CommentUpdated, events.CommentDeleted}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL that is sent comment events as they happen
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`      // used to sign deliveries; only shown once
+	Events    []string  `json:"events"` // empty means every event
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	u, err := url.Parse(webhook.URL)
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2048, "url", "must not be more than 2048 bytes long")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	for _, event := range webhook.Events {
+		v.Check(validator.PermittedValue(event, WebhookEvents...), "events", "invalid event "+event)
+	}
+	v.Check(len(webhook.Events) == len(slices.Compact(slices.Sorted(slices.Values(webhook.Events)))), "events", "must not contain duplicate values")
+}
+
+// WebhookDelivery is one event sent (or being sent) to a webhook
+type WebhookDelivery struct {
+	ID            int64             `json:"id"`
+	EventType     string            `json:"event"`
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt *time.Time        `json:"next_attempt_at,omitempty"` // only while pending
+	CreatedAt     time.Time         `json:"created_at"`
+	Log           []*WebhookAttempt `json:"log"`
+}
+
+// WebhookAttempt is one try at a delivery
+type WebhookAttempt struct {
+	Attempt     int       `json:"attempt"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int       `json:"duration_ms"`
+}
+
+// WebhookModel stores webhooks and their deliveries. It is also the
+// dispatcher's webhooks.Store.
+type WebhookModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration // how long a single query may run (default 3s)
+}
+
+var _ webhooks.Store = WebhookModel{}
+
+// queryTimeout returns the time limit for one query
+func (m WebhookModel) queryTimeout() time.Duration {
+	if m.QueryTimeout > 0 {
+		return m.QueryTimeout
+	}
+	return 3 * time.Second
+}
+
+func (m WebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+func (m WebhookModel) Get(ctx context.Context, id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, url, secret, events, active, version
+		FROM webhooks
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var webhook Webhook
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.URL,
+		&webhook.Secret, pq.Array(&webhook.Events), &webhook.Active, &webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &webhook, nil
+}
+
+func (m WebhookModel) GetAll(ctx context.Context, filters Filters) ([]*Webhook, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, url, secret, events, active, version
+		FROM webhooks
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	hooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(&totalRecords, &webhook.ID, &webhook.CreatedAt, &webhook.URL,
+			&webhook.Secret, pq.Array(&webhook.Events), &webhook.Active, &webhook.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		hooks = append(hooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return hooks, metadata, nil
+}
+
+// Update saves a webhook's URL, events and active flag. It returns
+// ErrEditConflict if the webhook changed since it was read.
+func (m WebhookModel) Update(ctx context.Context, webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, events = $2, active = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []any{webhook.URL, pq.Array(webhook.Events), webhook.Active, webhook.ID, webhook.Version}
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a webhook along with its deliveries
+func (m WebhookModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Deliveries lists a webhook's deliveries, newest first, each with the
+// log of its attempts. status filters by delivery status ("" for all).
+func (m WebhookModel) Deliveries(ctx context.Context, webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := `
+		SELECT COUNT(*) OVER(), d.id, d.created_at, o.event_type, d.status, d.attempts, d.next_attempt_at
+		FROM webhook_deliveries d
+		JOIN webhook_outbox o ON o.id = d.outbox_id
+		WHERE d.webhook_id = $1
+		AND (d.status = $2 OR $2 = '')
+		ORDER BY d.id DESC
+		LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+	byID := make(map[int64]*WebhookDelivery)
+
+	for rows.Next() {
+		var (
+			delivery    WebhookDelivery
+			nextAttempt time.Time
+		)
+		err := rows.Scan(&totalRecords, &delivery.ID, &delivery.CreatedAt, &delivery.EventType,
+			&delivery.Status, &delivery.Attempts, &nextAttempt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if delivery.Status == DeliveryPending {
+			delivery.NextAttemptAt = &nextAttempt
+		}
+		delivery.Log = []*WebhookAttempt{}
+		deliveries = append(deliveries, &delivery)
+		byID[delivery.ID] = &delivery
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Fetch the attempts for the whole page in one go
+	if len(deliveries) > 0 {
+		ids := make([]int64, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+
+		query = `
+			SELECT delivery_id, attempt, attempted_at, status_code, error, duration_ms
+			FROM webhook_delivery_attempts
+			WHERE delivery_id = ANY($1)
+			ORDER BY delivery_id, attempt`
+
+		rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				deliveryID int64
+				attempt    WebhookAttempt
+			)
+			err := rows.Scan(&deliveryID, &attempt.Attempt, &attempt.AttemptedAt,
+				&attempt.StatusCode, &attempt.Error, &attempt.DurationMS)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			byID[deliveryID].Log = append(byID[deliveryID].Log, &attempt)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// FanOut creates a delivery for every active webhook interested in each
+// of the next limit unprocessed outbox events. SKIP LOCKED lets several
+// API instances share the work without handling an event twice.
+func (m WebhookModel) FanOut(ctx context.Context, limit int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	var handled int
+	err := inTx(ctx, m.DB, func(tx *sql.Tx) error {
+		query := `
+			SELECT id
+			FROM webhook_outbox
+			WHERE processed_at IS NULL
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED`
+
+		rows, err := tx.QueryContext(ctx, query, limit)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			err = rows.Scan(&id)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		query = `
+			INSERT INTO webhook_deliveries (webhook_id, outbox_id)
+			SELECT w.id, o.id
+			FROM webhook_outbox o
+			JOIN webhooks w ON w.active AND (cardinality(w.events) = 0 OR o.event_type = ANY(w.events))
+			WHERE o.id = ANY($1)`
+		_, err = tx.ExecContext(ctx, query, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+
+		// Events no webhook wanted have nothing left to do
+		query = `
+			DELETE FROM webhook_outbox o
+			WHERE o.id = ANY($1)
+			AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.outbox_id = o.id)`
+		_, err = tx.ExecContext(ctx, query, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_outbox SET processed_at = NOW() WHERE id = ANY($1)`, pq.Array(ids))
+		handled = len(ids)
+		return err
+	})
+	return handled, err
+}
+
+// Prune deletes outbox events handed out more than maxAge ago whose
+// deliveries are all finished, and with them their delivery log. It
+// returns how many events went.
+func (m WebhookModel) Prune(ctx context.Context, maxAge time.Duration) (int64, error) {
+	query := `
+		DELETE FROM webhook_outbox o
+		WHERE o.processed_at < NOW() - make_interval(secs => $1)
+		AND NOT EXISTS (
+			SELECT 1 FROM webhook_deliveries d
+			WHERE d.outbox_id = o.id AND d.status = 'pending'
+		)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, maxAge.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Claim returns up to limit pending deliveries that are due and pushes
+// their next attempt back by lease, so nobody else sends them meanwhile.
+// If we die mid-delivery, it is tried again once the lease runs out.
+func (m WebhookModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]webhooks.Delivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1,
+			next_attempt_at = NOW() + make_interval(secs => $2),
+			updated_at = NOW()
+		FROM webhooks w, webhook_outbox o
+		WHERE d.id IN (
+			SELECT due.id
+			FROM webhook_deliveries due
+			JOIN webhooks active ON active.id = due.webhook_id AND active.active
+			WHERE due.status = 'pending' AND due.next_attempt_at <= NOW()
+			ORDER BY due.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF due SKIP LOCKED
+		)
+		AND w.id = d.webhook_id
+		AND o.id = d.outbox_id
+		RETURNING d.id, d.webhook_id, w.url, w.secret, o.event_type, o.payload, d.attempts`
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []webhooks.Delivery
+	for rows.Next() {
+		var delivery webhooks.Delivery
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.URL, &delivery.Secret,
+			&delivery.EventType, &delivery.Payload, &delivery.Attempt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// Record adds an attempt to the delivery log and moves the delivery on:
+// succeeded, failed (no more attempts) or pending until NextAttemptAt
+func (m WebhookModel) Record(ctx context.Context, attempt webhooks.Attempt) error {
+	status := DeliveryPending
+	nextAttemptAt := attempt.NextAttemptAt
+	switch {
+	case attempt.Succeeded:
+		status = DeliverySucceeded
+		nextAttemptAt = time.Now()
+	case attempt.NextAttemptAt.IsZero():
+		status = DeliveryFailed
+		nextAttemptAt = time.Now()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout())
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms)
+			VALUES ($1, $2, $3, $4, $5)`
+		args := []any{attempt.DeliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.Duration.Milliseconds()}
+		_, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE webhook_deliveries
+			SET status = $1, next_attempt_at = $2, updated_at = NOW()
+			WHERE id = $3`
+		_, err = tx.ExecContext(ctx, query, status, nextAttemptAt, attempt.DeliveryID)
+		return err
+	})
+}
@@ -0,0 +1,107 @@
+// Filename: internal/events/events.go
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Channel is the PostgreSQL NOTIFY channel comment changes are sent on
+const Channel = "qod_comment_events"
+
+// Event types
+const (
+	CommentCreated = "comment.created"
+	CommentUpdated = "comment.updated" // includes moderation status changes
+	CommentDeleted = "comment.deleted"
+	// Resync means some events may have been missed (the listener lost
+	// its connection, or a subscriber fell behind). Anything built from
+	// earlier events should be thrown away or reloaded.
+	Resync = "resync"
+)
+
+// Event describes a change to a comment. ID is its position in the
+// comment_events log. A comment is at most a few hundred bytes, so the
+// whole event fits in a NOTIFY payload (limited to 8000 bytes).
+// PreviousStatus is only set on updates.
+type Event struct {
+	ID             int64     `json:"id,omitempty"`
+	Type           string    `json:"type"`
+	CommentID      int64     `json:"comment_id,omitempty"`
+	Content        string    `json:"content,omitempty"`
+	Author         string    `json:"author,omitempty"`
+	Status         string    `json:"status,omitempty"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Version        int32     `json:"version,omitempty"`
+	At             time.Time `json:"at"`
+}
+
+// Bus hands events to the subscribers in this process
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives events on C until Unsubscribe is called
+type Subscription struct {
+	C <-chan Event
+
+	bus    *Bus
+	ch     chan Event
+	missed bool // an event was dropped; send Resync before the next one
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription buffering up to buffer events
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, bus: b, ch: ch}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe stops the subscription and closes C
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, found := s.bus.subscribers[s]; found {
+		delete(s.bus.subscribers, s)
+		close(s.ch)
+	}
+}
+
+// Publish sends event to every subscriber. It never blocks: a subscriber
+// whose buffer is full misses the event and is sent a Resync instead
+// once it has caught up.
+func (b *Bus) Publish(event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if sub.missed {
+			select {
+			case sub.ch <- Event{Type: Resync, At: event.At}:
+				sub.missed = false
+			default:
+				continue
+			}
+		}
+
+		select {
+		case sub.ch <- event:
+		default:
+			sub.missed = true
+		}
+	}
+}
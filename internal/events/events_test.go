@@ -0,0 +1,155 @@
+// Filename: internal/events/events_test.go
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// receive returns the next event on sub, failing the test if there isn't
+// one waiting
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-sub.C:
+		if !ok {
+			t.Fatal("the subscription is closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event was received")
+		return Event{}
+	}
+}
+
+// expectNothing fails the test if sub has an event waiting
+func expectNothing(t *testing.T, sub *Subscription) {
+	t.Helper()
+
+	select {
+	case event := <-sub.C:
+		t.Fatalf("got unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestBusFanOut(t *testing.T) {
+	bus := NewBus()
+	first := bus.Subscribe(10)
+	second := bus.Subscribe(10)
+
+	bus.Publish(Event{ID: 1, Type: CommentCreated, CommentID: 7})
+	for _, sub := range []*Subscription{first, second} {
+		event := receive(t, sub)
+		if event.ID != 1 || event.Type != CommentCreated || event.CommentID != 7 || event.At.IsZero() {
+			t.Errorf("got %+v", event)
+		}
+	}
+
+	// An unsubscribed subscription is closed and gets nothing more
+	first.Unsubscribe()
+	first.Unsubscribe() // a second call is harmless
+	bus.Publish(Event{ID: 2, Type: CommentDeleted})
+	if _, ok := <-first.C; ok {
+		t.Error("the first subscription got an event after Unsubscribe")
+	}
+	if event := receive(t, second); event.ID != 2 {
+		t.Errorf("got %+v", event)
+	}
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	slow := bus.Subscribe(2)
+	fast := bus.Subscribe(10)
+
+	// Publish never waits for a subscriber: the slow one's buffer fills
+	// up and it misses events 3 and 4
+	for id := range int64(4) {
+		bus.Publish(Event{ID: id + 1, Type: CommentUpdated})
+	}
+	for id := range int64(4) {
+		if event := receive(t, fast); event.ID != id+1 {
+			t.Errorf("fast subscriber got %+v; want event %d", event, id+1)
+		}
+	}
+
+	for id := range int64(2) {
+		if event := receive(t, slow); event.ID != id+1 {
+			t.Errorf("slow subscriber got %+v; want event %d", event, id+1)
+		}
+	}
+	expectNothing(t, slow)
+
+	// Once it has room it is told it missed something, then carries on
+	bus.Publish(Event{ID: 5, Type: CommentUpdated})
+	if event := receive(t, slow); event.Type != Resync {
+		t.Errorf("got %+v; want a resync", event)
+	}
+	if event := receive(t, slow); event.ID != 5 {
+		t.Errorf("got %+v; want event 5", event)
+	}
+	expectNothing(t, slow)
+}
+
+func TestListenerReceive(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(10)
+
+	var (
+		reconnects int
+		errs       []error
+		pings      = make(chan struct{}, 10)
+	)
+	l := &Listener{
+		Bus:         bus,
+		OnError:     func(err error) { errs = append(errs, err) },
+		OnReconnect: func() { reconnects++ },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	notify := make(chan *pq.Notification)
+	done := make(chan struct{})
+	go func() {
+		l.receive(ctx, notify, time.Millisecond, func() {
+			select {
+			case pings <- struct{}{}:
+			default:
+			}
+		})
+		close(done)
+	}()
+
+	notify <- &pq.Notification{Channel: Channel, Extra: `{"id":3,"type":"comment.created","comment_id":7}`}
+	if event := receive(t, sub); event.ID != 3 || event.Type != CommentCreated || event.CommentID != 7 {
+		t.Errorf("got %+v", event)
+	}
+
+	// A nil notification is the connection coming back, so anything sent
+	// while it was down is lost
+	notify <- nil
+	if event := receive(t, sub); event.Type != Resync {
+		t.Errorf("got %+v; want a resync", event)
+	}
+
+	notify <- &pq.Notification{Channel: Channel, Extra: "not json"}
+	select {
+	case <-pings:
+	case <-time.After(time.Second):
+		t.Error("the connection was never pinged")
+	}
+
+	cancel()
+	<-done
+	expectNothing(t, sub)
+	if reconnects != 1 {
+		t.Errorf("OnReconnect was called %d times; want 1", reconnects)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got errors %v; want one for the bad payload", errs)
+	}
+}
@@ -0,0 +1,86 @@
+// Filename: internal/events/listener.go
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Listener receives the NOTIFY messages sent by every API instance and
+// publishes them on a Bus
+type Listener struct {
+	DSN string
+	Bus *Bus
+	// OnError, if set, is told about connection problems and bad payloads
+	OnError func(err error)
+	// OnReconnect, if set, is called once the connection is back
+	OnReconnect func()
+}
+
+// Run listens until ctx is cancelled. pq.Listener reconnects on its own;
+// since anything sent while we were disconnected is lost, a Resync event
+// is published after each reconnect.
+func (l *Listener) Run(ctx context.Context) error {
+	listener := pq.NewListener(l.DSN, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			l.reportError(fmt.Errorf("events: listener connection: %w", err))
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(Channel)
+	if err != nil {
+		return err
+	}
+
+	// A quiet connection can die without us noticing, so ping it now
+	// and then
+	l.receive(ctx, listener.Notify, 90*time.Second, func() { go listener.Ping() })
+	return nil
+}
+
+// receive publishes the notifications from notify until ctx is
+// cancelled, calling ping every pingInterval
+func (l *Listener) receive(ctx context.Context, notify <-chan *pq.Notification, pingInterval time.Duration, ping func()) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case notification := <-notify:
+			// A nil notification means the connection was re-established
+			if notification == nil {
+				if l.OnReconnect != nil {
+					l.OnReconnect()
+				}
+				l.Bus.Publish(Event{Type: Resync})
+				continue
+			}
+
+			var event Event
+			err := json.Unmarshal([]byte(notification.Extra), &event)
+			if err != nil {
+				l.reportError(fmt.Errorf("events: bad payload %q: %w", notification.Extra, err))
+				continue
+			}
+			l.Bus.Publish(event)
+
+		case <-ticker.C:
+			ping()
+		}
+	}
+}
+
+func (l *Listener) reportError(err error) {
+	if l.OnError != nil {
+		l.OnError(err)
+	}
+}
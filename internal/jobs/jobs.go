@@ -0,0 +1,370 @@
+// Filename: internal/jobs/jobs.go
+
+// Package jobs is a background job queue kept in PostgreSQL. Jobs are
+// rows in the jobs table; workers in every API instance claim them with
+// SELECT ... FOR UPDATE SKIP LOCKED, so each job runs once at a time
+// however many instances there are. A failed job is retried with
+// exponential backoff until it runs out of attempts, and is then left
+// in the table as "dead".
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDead    = "dead"
+)
+
+// Job is one unit of work
+type Job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Attempt     int // this attempt, starting at 1
+	MaxAttempts int
+	CreatedAt   time.Time
+}
+
+// Decode unmarshals the job's payload into dst
+func (j Job) Decode(dst any) error {
+	return json.Unmarshal(j.Payload, dst)
+}
+
+// Handler does the work for a job. Returning an error (or panicking)
+// means the job is tried again later.
+type Handler func(ctx context.Context, job Job) error
+
+// Options control how jobs of one kind are run
+type Options struct {
+	Timeout    time.Duration // time limit for one attempt (default 1m)
+	Backoff    time.Duration // wait before the second attempt; doubles each time (default 10s)
+	MaxBackoff time.Duration // default 1h
+}
+
+// Execer is a *sql.DB or a *sql.Tx. Enqueue with a *sql.Tx to add a job
+// only if the rest of the transaction commits.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// EnqueueOptions are the optional settings for a new job
+type EnqueueOptions struct {
+	// Key, if set, stops the job being added while another job with the
+	// same key is queued or running
+	Key         string
+	RunAt       time.Time // default now
+	MaxAttempts int       // default 5
+}
+
+// Enqueue adds a job. payload is stored as JSON.
+func Enqueue(ctx context.Context, db Execer, kind string, payload any, opts EnqueueOptions) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if opts.RunAt.IsZero() {
+		opts.RunAt = time.Now()
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 5
+	}
+	key := sql.NullString{String: opts.Key, Valid: opts.Key != ""}
+
+	query := `
+		INSERT INTO jobs (kind, key, payload, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key) WHERE key IS NOT NULL AND status <> 'dead' DO NOTHING`
+
+	_, err = db.ExecContext(ctx, query, kind, key, js, opts.MaxAttempts, opts.RunAt)
+	return err
+}
+
+// Result is what happened to one attempt
+type Result struct {
+	Job      Job
+	Err      error  // nil on success
+	Outcome  string // "succeeded", "retrying" or "dead"
+	Duration time.Duration
+}
+
+type registration struct {
+	handler Handler
+	opts    Options
+}
+
+// Runner runs the registered kinds of job with a pool of workers
+type Runner struct {
+	DB           *sql.DB
+	Workers      int
+	PollInterval time.Duration // how often an idle worker looks for work
+	// OnResult, if set, is told about every attempt
+	OnResult func(result Result)
+	// OnError, if set, is told when the queue itself fails
+	OnError func(err error)
+
+	handlers map[string]registration
+	wake     chan struct{}
+	stop     chan struct{} // closed to stop claiming jobs
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewRunner(db *sql.DB, workers int, pollInterval time.Duration) *Runner {
+	return &Runner{
+		DB:           db,
+		Workers:      workers,
+		PollInterval: pollInterval,
+		handlers:     make(map[string]registration),
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Register sets the handler for a kind of job. Only registered kinds are
+// claimed, so instances running different versions can share the table.
+// Call it before Start.
+func (r *Runner) Register(kind string, handler Handler, opts Options) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Minute
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 10 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	r.handlers[kind] = registration{handler: handler, opts: opts}
+}
+
+// Enqueue adds a job and wakes a worker in this instance to run it
+func (r *Runner) Enqueue(ctx context.Context, kind string, payload any, opts EnqueueOptions) error {
+	err := Enqueue(ctx, r.DB, kind, payload, opts)
+	if err != nil {
+		return err
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start launches the workers
+func (r *Runner) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	for range max(r.Workers, 1) {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.work(ctx)
+		}()
+	}
+}
+
+// Stop makes the workers finish the jobs they are running and exit. If
+// ctx expires first the running jobs are cancelled, which counts as a
+// failed attempt, so they are retried later; Stop still waits for the
+// workers to return.
+func (r *Runner) Stop(ctx context.Context) error {
+	close(r.stop)
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	if r.cancel == nil {
+		return nil // never started
+	}
+
+	select {
+	case <-done:
+		r.cancel()
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-done
+		return fmt.Errorf("jobs: running jobs cancelled: %w", ctx.Err())
+	}
+}
+
+// work claims and runs jobs until Stop is called
+func (r *Runner) work(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while there is work, then wait
+		for {
+			select {
+			case <-r.stop:
+				return
+			default:
+			}
+
+			job, found, err := r.claim(ctx)
+			if err != nil {
+				r.reportError(err)
+				break
+			}
+			if !found {
+				break
+			}
+			r.run(ctx, job)
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// lease is how long a claimed job is hidden from other workers. If we
+// die while running it, it is run again once the lease runs out.
+func (r *Runner) lease() time.Duration {
+	var longest time.Duration
+	for _, reg := range r.handlers {
+		longest = max(longest, reg.opts.Timeout)
+	}
+	return longest + time.Minute
+}
+
+// claim takes the next job that is due, or one whose worker died
+func (r *Runner) claim(ctx context.Context) (Job, bool, error) {
+	kinds := make([]string, 0, len(r.handlers))
+	for kind := range r.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1,
+			locked_until = NOW() + make_interval(secs => $2), updated_at = NOW()
+		WHERE id = (
+			SELECT id
+			FROM jobs
+			WHERE kind = ANY($1)
+			AND run_at <= NOW()
+			AND (status = 'queued' OR (status = 'running' AND locked_until < NOW()))
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts, max_attempts, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var job Job
+	err := r.DB.QueryRowContext(ctx, query, pq.Array(kinds), r.lease().Seconds()).Scan(
+		&job.ID, &job.Kind, &job.Payload, &job.Attempt, &job.MaxAttempts, &job.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, false, nil
+		}
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+// run runs one job and records how it went
+func (r *Runner) run(ctx context.Context, job Job) {
+	reg := r.handlers[job.Kind]
+	start := time.Now()
+
+	var err error
+	if job.Attempt > job.MaxAttempts {
+		// Its worker kept dying before it could record a result
+		err = errors.New("jobs: lease expired on the last attempt")
+	} else {
+		err = r.call(ctx, reg, job)
+	}
+
+	result := Result{Job: job, Err: err, Duration: time.Since(start)}
+
+	// Record the result even if we are being stopped
+	ctx = context.WithoutCancel(ctx)
+	switch {
+	case err == nil:
+		result.Outcome = "succeeded"
+		err = r.exec(ctx, `DELETE FROM jobs WHERE id = $1 AND attempts = $2`, job.ID, job.Attempt)
+	case job.Attempt >= job.MaxAttempts:
+		result.Outcome = "dead"
+		err = r.exec(ctx, `
+			UPDATE jobs
+			SET status = 'dead', last_error = $3, locked_until = NULL, updated_at = NOW()
+			WHERE id = $1 AND attempts = $2`, job.ID, job.Attempt, err.Error())
+	default:
+		result.Outcome = "retrying"
+		runAt := time.Now().Add(backoff(reg.opts, job.Attempt))
+		err = r.exec(ctx, `
+			UPDATE jobs
+			SET status = 'queued', last_error = $3, run_at = $4, locked_until = NULL, updated_at = NOW()
+			WHERE id = $1 AND attempts = $2`, job.ID, job.Attempt, err.Error(), runAt)
+	}
+	if err != nil {
+		r.reportError(err)
+	}
+
+	if r.OnResult != nil {
+		r.OnResult(result)
+	}
+}
+
+// call runs the handler, turning a panic into an error
+func (r *Runner) call(ctx context.Context, reg registration, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("jobs: panic: %v\n%s", p, debug.Stack())
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, reg.opts.Timeout)
+	defer cancel()
+
+	return reg.handler(ctx, job)
+}
+
+func (r *Runner) exec(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *Runner) reportError(err error) {
+	if r.OnError != nil && !errors.Is(err, context.Canceled) {
+		r.OnError(err)
+	}
+}
+
+// backoff returns the wait after the given attempt, with jitter so that
+// jobs which failed together don't all come back at once
+func backoff(opts Options, attempt int) time.Duration {
+	wait := opts.Backoff << min(attempt-1, 30)
+	if wait <= 0 || wait > opts.MaxBackoff {
+		wait = opts.MaxBackoff
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
@@ -0,0 +1,245 @@
+// Filename: internal/jobs/jobs_test.go
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mickali02/qod/internal/migrate"
+	"github.com/mickali02/qod/migrations"
+)
+
+func TestBackoff(t *testing.T) {
+	opts := Options{Backoff: 10 * time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		attempt int
+		wait    time.Duration // before jitter
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := backoff(opts, tt.attempt)
+			if got < tt.wait/2 || got > tt.wait {
+				t.Errorf("backoff(%d) = %v; want between %v and %v", tt.attempt, got, tt.wait/2, tt.wait)
+				break
+			}
+		}
+	}
+}
+
+func TestRegisterDefaults(t *testing.T) {
+	r := NewRunner(nil, 1, time.Second)
+	r.Register("short", nil, Options{Timeout: time.Second})
+	r.Register("default", nil, Options{})
+
+	got := r.handlers["default"].opts
+	if got.Timeout != time.Minute || got.Backoff != 10*time.Second || got.MaxBackoff != time.Hour {
+		t.Errorf("default options are %+v", got)
+	}
+	// The lease outlasts the slowest kind of job
+	if lease := r.lease(); lease != 2*time.Minute {
+		t.Errorf("lease = %v; want 2m", lease)
+	}
+}
+
+// openTestDB connects to the database in QOD_TEST_DB_DSN, migrates it
+// and empties the jobs table, or skips the test if there isn't one
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("QOD_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("QOD_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := t.Context()
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, `TRUNCATE jobs`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// stopRunner stops r when the test ends
+func stopRunner(t *testing.T, r *Runner) {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := r.Stop(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestJobDiesAfterMaxAttempts(t *testing.T) {
+	db := openTestDB(t)
+
+	results := make(chan Result, 10)
+	r := NewRunner(db, 1, 10*time.Millisecond)
+	r.OnResult = func(result Result) { results <- result }
+	r.OnError = func(err error) { t.Error(err) }
+	r.Register("fail", func(ctx context.Context, job Job) error {
+		return errors.New("no luck")
+	}, Options{Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	r.Start()
+	stopRunner(t, r)
+
+	err := r.Enqueue(t.Context(), "fail", map[string]string{"hello": "world"}, EnqueueOptions{Key: "nightly", MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"retrying", "retrying", "dead"} {
+		select {
+		case result := <-results:
+			if result.Outcome != want || result.Err == nil {
+				t.Fatalf("attempt %d: got %q (%v); want %q", result.Job.Attempt, result.Outcome, result.Err, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for a %q attempt", want)
+		}
+	}
+
+	var (
+		status    string
+		attempts  int
+		lastError string
+	)
+	err = db.QueryRowContext(t.Context(), `SELECT status, attempts, last_error FROM jobs`).Scan(&status, &attempts, &lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusDead || attempts != 3 || lastError != "no luck" {
+		t.Errorf("job is %s after %d attempts with error %q", status, attempts, lastError)
+	}
+
+	// A dead job doesn't hold on to its key
+	err = Enqueue(t.Context(), db, "fail", nil, EnqueueOptions{Key: "nightly", RunAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = db.QueryRowContext(t.Context(), `SELECT COUNT(*) FROM jobs WHERE key = 'nightly'`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%d jobs with the dead job's key; want 2", count)
+	}
+}
+
+func TestClaimSkipsLockedJobs(t *testing.T) {
+	db := openTestDB(t)
+	r := NewRunner(db, 1, time.Second)
+	r.Register("work", func(ctx context.Context, job Job) error { return nil }, Options{})
+
+	err := Enqueue(t.Context(), db, "work", nil, EnqueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While another worker has the row locked, claim moves on rather than
+	// waiting for it
+	tx, err := db.BeginTx(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(t.Context(), `SELECT id FROM jobs FOR UPDATE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, found, err := r.claim(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found || time.Since(start) > time.Second {
+		t.Errorf("claim found %v after %v; want nothing, straight away", found, time.Since(start))
+	}
+
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err = r.claim(t.Context()); err != nil || !found {
+		t.Errorf("claim after the lock was released: found %v, error %v", found, err)
+	}
+}
+
+func TestEachJobRunsOnce(t *testing.T) {
+	db := openTestDB(t)
+
+	const total = 50
+	var (
+		mu   sync.Mutex
+		runs = make(map[int64]int)
+		done = make(chan struct{})
+	)
+	handler := func(ctx context.Context, job Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs[job.ID]++
+		if runs[job.ID] == 1 && len(runs) == total {
+			close(done)
+		}
+		return nil
+	}
+
+	for range total {
+		err := Enqueue(t.Context(), db, "work", nil, EnqueueOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two instances with a few workers each share the queue
+	for range 2 {
+		r := NewRunner(db, 4, 10*time.Millisecond)
+		r.OnError = func(err error) { t.Error(err) }
+		r.Register("work", handler, Options{})
+		r.Start()
+		stopRunner(t, r)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the jobs to run")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for id, count := range runs {
+		if count != 1 {
+			t.Errorf("job %d ran %d times", id, count)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Filename: internal/limiter/limiter.go
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// Result is the outcome of checking a rate limit. It carries what the
+// API needs to fill in the X-RateLimit-* and Retry-After headers.
+type Result struct {
+	Allowed    bool
+	Limit      int           // requests allowed in a full bucket/window
+	Remaining  int           // requests left right now
+	Reset      time.Duration // time until a used request is given back (0 if none are used)
+	RetryAfter time.Duration // time until the next request is allowed
+}
+
+// Limiter is a store which keeps track of how many requests each key
+// (an IP address, an author name, ...) has made.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// Janitor is implemented by limiters which need their old entries
+// removed now and then. RunJanitor does that every minute until ctx is
+// cancelled.
+type Janitor interface {
+	RunJanitor(ctx context.Context)
+}
@@ -0,0 +1,104 @@
+// Filename: internal/limiter/memory.go
+package limiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Memory is a token bucket limiter which lives in this process. Each
+// replica of the API keeps its own counts.
+type Memory struct {
+	rps   float64
+	burst int
+	now   func() time.Time // the clock; tests replace it
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewMemory creates a limiter which allows rps requests per second with
+// bursts of up to burst requests for each key. Run RunJanitor in the
+// background to stop the clients map growing forever.
+func NewMemory(rps float64, burst int) *Memory {
+	return &Memory{
+		rps:     rps,
+		burst:   burst,
+		now:     time.Now,
+		clients: make(map[string]*client),
+	}
+}
+
+// RunJanitor removes clients we haven't seen for 3 minutes, every
+// minute, until ctx is cancelled
+func (m *Memory) RunJanitor(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		now := m.now()
+		for key, client := range m.clients {
+			if now.Sub(client.lastSeen) > 3*time.Minute {
+				delete(m.clients, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Allow takes a token from the bucket for key if one is available
+func (m *Memory) Allow(ctx context.Context, key string) (Result, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(m.rps), m.burst)}
+		m.clients[key] = c
+	}
+	c.lastSeen = now
+
+	allowed := c.limiter.AllowN(now, 1)
+	tokens := c.limiter.TokensAt(now)
+
+	remaining := max(int(math.Floor(tokens)), 0)
+	result := Result{
+		Allowed:   allowed,
+		Limit:     m.burst,
+		Remaining: remaining,
+	}
+	// Tokens come back one at a time, so the next one is the reset
+	if remaining < m.burst {
+		result.Reset = m.refillTime(float64(remaining+1) - tokens)
+	}
+	if !allowed {
+		result.RetryAfter = m.refillTime(1 - tokens)
+	}
+	return result, nil
+}
+
+// refillTime is how long it takes to earn back the given number of tokens
+func (m *Memory) refillTime(tokens float64) time.Duration {
+	if tokens <= 0 || m.rps <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / m.rps * float64(time.Second))
+}
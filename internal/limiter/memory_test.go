@@ -0,0 +1,67 @@
+// Filename: internal/limiter/memory_test.go
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryAllow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory(2, 3)
+	m.now = func() time.Time { return now }
+
+	// A token comes back every 500ms, up to 3
+	steps := []struct {
+		advance time.Duration
+		want    Result
+	}{
+		{0, Result{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}},
+		{0, Result{Allowed: true, Limit: 3, Remaining: 1, Reset: 500 * time.Millisecond}},
+		{0, Result{Allowed: true, Limit: 3, Remaining: 0, Reset: 500 * time.Millisecond}},
+		{0, Result{Allowed: false, Limit: 3, Remaining: 0, Reset: 500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+		{250 * time.Millisecond, Result{Allowed: false, Limit: 3, Remaining: 0, Reset: 250 * time.Millisecond, RetryAfter: 250 * time.Millisecond}},
+		{250 * time.Millisecond, Result{Allowed: true, Limit: 3, Remaining: 0, Reset: 500 * time.Millisecond}},
+		{time.Minute, Result{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}},
+	}
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		got, err := m.Allow(t.Context(), "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("step %d: got %+v; want %+v", i, got, step.want)
+		}
+	}
+
+	// Each key has its own bucket
+	got, err := m.Allow(t.Context(), "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Allowed || got.Remaining != 2 {
+		t.Errorf("another key got %+v", got)
+	}
+}
+
+func TestMemoryZeroRate(t *testing.T) {
+	m := NewMemory(0, 1)
+
+	first, err := m.Allow(t.Context(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Allow(t.Context(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing ever comes back, so there's no reset to report
+	if !first.Allowed || first.Reset != 0 {
+		t.Errorf("first request got %+v", first)
+	}
+	if second.Allowed || second.RetryAfter != 0 {
+		t.Errorf("second request got %+v", second)
+	}
+}
@@ -0,0 +1,165 @@
+// Filename: internal/limiter/postgres.go
+package limiter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Postgres is a sliding window limiter which keeps its counts in
+// PostgreSQL, so every replica of the API shares the same budget.
+// It allows Limit requests in any Window.
+type Postgres struct {
+	DB     *sql.DB
+	Name   string // keeps the keys of different limiters apart
+	Limit  int
+	Window time.Duration
+	// QueryTimeout is how long Allow may take (default 3s), and
+	// CleanupTimeout how long the janitor may take (default 10s)
+	QueryTimeout   time.Duration
+	CleanupTimeout time.Duration
+	// OnError, if set, is told when the janitor fails to clear out hits
+	OnError func(err error)
+}
+
+// NewPostgres creates a sliding window limiter that matches a token
+// bucket of rps requests per second with bursts of up to burst requests.
+// Run RunJanitor in the background to clear out old hits.
+func NewPostgres(db *sql.DB, name string, rps float64, burst int) *Postgres {
+	window := time.Minute
+	if rps > 0 {
+		window = time.Duration(float64(burst) / rps * float64(time.Second))
+	}
+
+	return &Postgres{
+		DB:     db,
+		Name:   name,
+		Limit:  burst,
+		Window: window,
+	}
+}
+
+// timeout returns d, or def if d isn't set
+func timeout(d time.Duration, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// RunJanitor removes hits which have left the window (for keys we have
+// stopped seeing) every minute until ctx is cancelled
+func (p *Postgres) RunJanitor(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.cleanup(ctx)
+		}
+	}
+}
+
+// Allow records a hit for key if it has not used up its window
+func (p *Postgres) Allow(ctx context.Context, key string) (Result, error) {
+	key = p.Name + ":" + key
+	window := p.Window.Seconds()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout(p.QueryTimeout, 3*time.Second))
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	// Serialise requests for the same key so two replicas can't both
+	// take the last slot in the window.
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key)
+	if err != nil {
+		return Result{}, err
+	}
+
+	query := `
+		DELETE FROM rate_limit_hits
+		WHERE key = $1 AND hit_at <= clock_timestamp() - make_interval(secs => $2)`
+
+	_, err = tx.ExecContext(ctx, query, key, window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// How many hits are in the window, and when does the oldest of
+	// them fall out of it (giving its request back)?
+	query = `
+		SELECT COUNT(*),
+		       COALESCE(EXTRACT(EPOCH FROM MIN(hit_at) + make_interval(secs => $2) - clock_timestamp()), 0)
+		FROM rate_limit_hits
+		WHERE key = $1`
+
+	var (
+		count         int
+		oldestExpires float64
+	)
+	err = tx.QueryRowContext(ctx, query, key, window).Scan(&count, &oldestExpires)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if count >= p.Limit {
+		return Result{
+			Allowed:    false,
+			Limit:      p.Limit,
+			Remaining:  0,
+			Reset:      seconds(oldestExpires),
+			RetryAfter: seconds(oldestExpires),
+		}, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO rate_limit_hits (key) VALUES ($1)`, key)
+	if err != nil {
+		return Result{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Result{}, err
+	}
+
+	// With no earlier hits, the one we just made is the oldest
+	reset := p.Window
+	if count > 0 {
+		reset = seconds(oldestExpires)
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     p.Limit,
+		Remaining: p.Limit - count - 1,
+		Reset:     reset,
+	}, nil
+}
+
+// cleanup deletes every hit that has left the window
+func (p *Postgres) cleanup(ctx context.Context) {
+	query := `
+		DELETE FROM rate_limit_hits
+		WHERE key LIKE $1 || ':%' AND hit_at <= clock_timestamp() - make_interval(secs => $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, timeout(p.CleanupTimeout, 10*time.Second))
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx, query, p.Name, p.Window.Seconds())
+	if err != nil && p.OnError != nil && !errors.Is(err, context.Canceled) {
+		p.OnError(err)
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(max(s, 0) * float64(time.Second))
+}
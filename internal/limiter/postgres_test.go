@@ -0,0 +1,145 @@
+// Filename: internal/limiter/postgres_test.go
+package limiter
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/mickali02/qod/internal/migrate"
+	"github.com/mickali02/qod/migrations"
+)
+
+// openTestDB connects to the database in QOD_TEST_DB_DSN, migrates it
+// and clears out the rate limit hits, or skips the test if there isn't
+// one
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("QOD_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("QOD_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := t.Context()
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, `TRUNCATE rate_limit_hits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPostgresAllow(t *testing.T) {
+	db := openTestDB(t)
+	p := NewPostgres(db, "test", 1, 2)
+	if p.Window != 2*time.Second {
+		t.Fatalf("Window = %v; want 2s", p.Window)
+	}
+
+	// The first hit is the oldest, so it is a whole window from expiring
+	first, err := p.Allow(t.Context(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Allowed || first.Limit != 2 || first.Remaining != 1 || first.Reset != p.Window {
+		t.Errorf("first request got %+v", first)
+	}
+
+	// Later hits are reset by the first one leaving the window
+	second, err := p.Allow(t.Context(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Allowed || second.Remaining != 0 || second.Reset <= 0 || second.Reset > first.Reset {
+		t.Errorf("second request got %+v", second)
+	}
+
+	third, err := p.Allow(t.Context(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Allowed || third.Remaining != 0 || third.RetryAfter <= 0 || third.RetryAfter > p.Window || third.Reset != third.RetryAfter {
+		t.Errorf("third request got %+v", third)
+	}
+
+	// Other keys, and the same key in another limiter, have their own windows
+	for _, other := range []*Postgres{p, NewPostgres(db, "other", 1, 2)} {
+		got, err := other.Allow(t.Context(), "192.0.2.2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Allowed || got.Remaining != 1 {
+			t.Errorf("%s limiter, another key got %+v", other.Name, got)
+		}
+	}
+
+	// Once the window has passed the key gets its budget back
+	time.Sleep(third.RetryAfter + 100*time.Millisecond)
+	got, err := p.Allow(t.Context(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Allowed {
+		t.Errorf("after the window got %+v", got)
+	}
+}
+
+func TestPostgresCleanup(t *testing.T) {
+	db := openTestDB(t)
+	p := NewPostgres(db, "test", 10, 1)
+	var failed error
+	p.OnError = func(err error) { failed = err }
+
+	_, err := p.Allow(t.Context(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPostgres(db, "other", 10, 1).Allow(t.Context(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(p.Window + 100*time.Millisecond)
+	p.cleanup(t.Context())
+	if failed != nil {
+		t.Fatal(failed)
+	}
+
+	// Only this limiter's old hits are removed
+	var keys []string
+	rows, err := db.QueryContext(t.Context(), `SELECT key FROM rate_limit_hits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		err = rows.Scan(&key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "other:192.0.2.1" {
+		t.Errorf("hits left: %q; want just the other limiter's", keys)
+	}
+}
@@ -0,0 +1,150 @@
+// Filename: internal/metrics/metrics_test.go
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// render writes the collectors the way /v1/metrics does
+func render(collectors ...Collector) string {
+	registry := NewRegistry()
+	registry.Register(collectors...)
+
+	var b strings.Builder
+	registry.WriteText(&b)
+	return b.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	requests := NewCounterVec("http_requests_total", "Total requests.", "route", "status")
+	requests.Inc("/v1/comments", "200")
+	requests.Inc("/v1/comments", "200")
+	requests.Add(2.5, "/v1/comments/:id", "404")
+
+	// Series come out in a fixed order (sorted by their label values)
+	want := `# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{route="/v1/comments/:id",status="404"} 2.5
+http_requests_total{route="/v1/comments",status="200"} 2
+`
+	if got := render(requests); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterVecWithoutLabels(t *testing.T) {
+	panics := NewCounterVec("panics_total", "Panics.")
+	panics.Inc()
+
+	want := `# HELP panics_total Panics.
+# TYPE panics_total counter
+panics_total 1
+`
+	if got := render(panics); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterVecWrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for the wrong number of label values")
+		}
+	}()
+
+	NewCounterVec("c", "C.", "a", "b").Inc("only-one")
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounterVec("c", "C.", "path")
+	c.Inc("a\"b\\c\nd")
+
+	want := `c{path="a\"b\\c\nd"} 1`
+	if got := render(c); !strings.Contains(got, want) {
+		t.Errorf("got:\n%s\nwant a line:\n%s", got, want)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("in_flight", "In flight.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	g.Add(0.5)
+
+	want := `# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 1.5
+`
+	if got := render(g); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	g.Set(-3)
+	if got := render(g); !strings.HasSuffix(got, "in_flight -3\n") {
+		t.Errorf("after Set got:\n%s", got)
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	value := 1.0
+	gauge := NewGaugeFunc("open", "Open.", func() float64 { return value })
+	counter := NewCounterFunc("waits_total", "Waits.", func() float64 { return value * 10 })
+
+	value = 4
+	want := `# HELP open Open.
+# TYPE open gauge
+open 4
+# HELP waits_total Waits.
+# TYPE waits_total counter
+waits_total 40
+`
+	if got := render(gauge, counter); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	// Buckets are sorted whatever order they are given in
+	h := NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1, 0.5}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a") // on a bound: counted in that bucket
+	h.Observe(0.3, "/a")
+	h.Observe(2, "/a") // only in +Inf
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="0.5"} 3
+latency_seconds_bucket{route="/a",le="1"} 3
+latency_seconds_bucket{route="/a",le="+Inf"} 4
+latency_seconds_sum{route="/a"} 2.45
+latency_seconds_count{route="/a"} 4
+`
+	if got := render(h); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{0.005, "0.005"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := formatFloat(tt.in); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
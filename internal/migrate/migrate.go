@@ -0,0 +1,286 @@
+// Filename: internal/migrate/migrate.go
+
+// Package migrate applies the numbered SQL migrations in migrations/.
+// It keeps its state in the same schema_migrations table as the
+// golang-migrate CLI, so the two can be used on the same database.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrDirty       = errors.New("migrate: database is dirty, fix it by hand and force the version")
+	ErrNoMigration = errors.New("migrate: no such migration version")
+)
+
+// advisoryLockID stops two copies of the API migrating at the same time
+const advisoryLockID = 7238112901
+
+// filenames look like 000001_create_comments_table.up.sql
+var filenameRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is one numbered step
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	migrations []Migration // sorted by version
+}
+
+// New reads the migrations from fsys (e.g. migrations.FS)
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := filenameRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: bad version in %s", entry.Name())
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, found := byVersion[version]
+		if !found {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+		if matches[3] == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrator := &Migrator{DB: db}
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up migration", m.Version)
+		}
+		migrator.migrations = append(migrator.migrations, *m)
+	}
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].Version < migrator.migrations[j].Version
+	})
+
+	return migrator, nil
+}
+
+// Latest returns the highest migration version we know about
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version the database is at (0 if none has been
+// applied) and whether a failed migration left it dirty
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	return version(ctx, m.DB)
+}
+
+// Status lists every migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	current, _, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		statuses = append(statuses, Status{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Applied: migration.Version <= current,
+		})
+	}
+	return statuses, nil
+}
+
+// Up applies every migration that hasn't been applied yet
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down rolls back the most recent migration
+func (m *Migrator) Down(ctx context.Context) error {
+	current, _, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	target := int64(0)
+	for _, migration := range m.migrations {
+		if migration.Version < current {
+			target = migration.Version
+		}
+	}
+	return m.To(ctx, target)
+}
+
+// To migrates up or down until the database is at target. A target of
+// 0 rolls back every migration.
+func (m *Migrator) To(ctx context.Context, target int64) error {
+	if target != 0 && m.index(target) < 0 {
+		return ErrNoMigration
+	}
+
+	// Use one connection for the whole run so the advisory lock (which
+	// belongs to a session) is held the entire time
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockID)
+
+	err = m.ensureTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := version(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty
+	}
+
+	// Going up: apply each migration after current, up to target
+	for _, migration := range m.migrations {
+		if migration.Version > current && migration.Version <= target {
+			err = m.apply(ctx, conn, migration.up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: up %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+	}
+
+	// Going down: undo each migration after target, newest first
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version <= current && migration.Version > target {
+			if migration.down == "" {
+				return fmt.Errorf("migrate: version %d has no down migration", migration.Version)
+			}
+			previous := int64(0)
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+			err = m.apply(ctx, conn, migration.down, previous)
+			if err != nil {
+				return fmt.Errorf("migrate: down %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// apply runs one migration and records the new version in the same
+// transaction, so a failure leaves the database where it was
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, statements string, newVersion int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, statements)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	if newVersion > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, newVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) index(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// execer is the part of *sql.DB and *sql.Conn that we need
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, db execer) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`)
+	return err
+}
+
+func version(ctx context.Context, db execer) (int64, bool, error) {
+	// A database that has never been migrated has no table at all
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+
+	var (
+		v     int64
+		dirty bool
+	)
+	err = db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return v, dirty, err
+}
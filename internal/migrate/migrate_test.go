@@ -0,0 +1,314 @@
+// Filename: internal/migrate/migrate_test.go
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/lib/pq"
+	"github.com/mickali02/qod/migrations"
+)
+
+// file returns a MapFS file holding body
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func TestNewSortsByVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"10_tenth.up.sql":        file("SELECT 10"),
+		"000002_second.up.sql":   file("SELECT 2"),
+		"000002_second.down.sql": file("SELECT -2"),
+		"000001_first.up.sql":    file("SELECT 1"),
+		"README.md":              file("not a migration"),
+		"000003_nested/x.up.sql": file("not a migration either"),
+	}
+	m, err := New(nil, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Versions are compared as numbers, so 10 comes after 2 even though
+	// its file name sorts first
+	var got []string
+	for _, migration := range m.migrations {
+		got = append(got, fmt.Sprintf("%d_%s", migration.Version, migration.Name))
+	}
+	if strings.Join(got, " ") != "1_first 2_second 10_tenth" {
+		t.Errorf("migrations = %q", got)
+	}
+	if m.Latest() != 10 {
+		t.Errorf("Latest = %d; want 10", m.Latest())
+	}
+	second := m.migrations[m.index(2)]
+	if second.up != "SELECT 2" || second.down != "SELECT -2" {
+		t.Errorf("version 2 is %+v", second)
+	}
+	if m.index(3) != -1 {
+		t.Error("the nested file was read as a migration")
+	}
+}
+
+func TestNewNeedsAnUpMigration(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000001_first.up.sql":    file("SELECT 1"),
+		"000002_second.down.sql": file("SELECT -2"),
+	}
+	_, err := New(nil, fsys)
+	if err == nil || !strings.Contains(err.Error(), "version 2 has no up migration") {
+		t.Errorf("got error %v", err)
+	}
+
+	m, err := New(nil, fstest.MapFS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Latest() != 0 {
+		t.Errorf("Latest with no migrations = %d", m.Latest())
+	}
+}
+
+func TestMigrationsFS(t *testing.T) {
+	m, err := New(nil, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every migration can be rolled back, and none is missing
+	for i, migration := range m.migrations {
+		if migration.Version != int64(i+1) {
+			t.Errorf("migration %d has version %d", i+1, migration.Version)
+		}
+		if migration.down == "" {
+			t.Errorf("%d_%s has no down migration", migration.Version, migration.Name)
+		}
+	}
+}
+
+// testFS is three migrations, each creating a table
+var testFS = fstest.MapFS{
+	"000001_create_a.up.sql":   file("CREATE TABLE a (id int)"),
+	"000001_create_a.down.sql": file("DROP TABLE a"),
+	"000002_create_b.up.sql":   file("CREATE TABLE b (id int)"),
+	"000002_create_b.down.sql": file("DROP TABLE b"),
+	"000003_create_c.up.sql":   file("CREATE TABLE c (id int)"),
+	"000003_create_c.down.sql": file("DROP TABLE c"),
+}
+
+// openTestDB connects to the database in QOD_TEST_DB_DSN with a schema
+// of its own, so the other packages' tables and migration version are
+// left alone, or skips the test if there isn't one
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("QOD_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("QOD_TEST_DB_DSN is not set")
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("postgres", dsn+" search_path=migrate_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.ExecContext(t.Context(), `DROP SCHEMA IF EXISTS migrate_test CASCADE; CREATE SCHEMA migrate_test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// tables returns which of a, b and c exist
+func tables(t *testing.T, db *sql.DB) string {
+	t.Helper()
+
+	var names []string
+	for _, name := range []string{"a", "b", "c"} {
+		var exists bool
+		err := db.QueryRowContext(t.Context(), `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// expectVersion fails the test unless the database is at version and
+// clean
+func expectVersion(t *testing.T, m *Migrator, want int64) {
+	t.Helper()
+
+	v, dirty, err := m.Version(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != want || dirty {
+		t.Errorf("version = %d (dirty %v); want %d", v, dirty, want)
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	db := openTestDB(t)
+	m, err := New(db, testFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A database that has never been migrated is at version 0
+	expectVersion(t, m, 0)
+
+	err = m.Up(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 3)
+	if got := tables(t, db); got != "a b c" {
+		t.Errorf("after Up, tables = %q", got)
+	}
+
+	// Running Up again has nothing to do
+	err = m.Up(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Down(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 2)
+	statuses, err := m.Status(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Applied != (status.Version <= 2) {
+			t.Errorf("status %+v", status)
+		}
+	}
+
+	err = m.To(t.Context(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 0)
+	if got := tables(t, db); got != "" {
+		t.Errorf("after To(0), tables = %q", got)
+	}
+
+	if err = m.To(t.Context(), 99); !errors.Is(err, ErrNoMigration) {
+		t.Errorf("To(99): got error %v; want ErrNoMigration", err)
+	}
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	db := openTestDB(t)
+	fsys := fstest.MapFS{
+		"000001_create_a.up.sql": file("CREATE TABLE a (id int)"),
+		"000002_create_b.up.sql": file("CREATE TABLE b (id int); SELECT * FROM missing"),
+	}
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Up(t.Context())
+	if err == nil || !strings.Contains(err.Error(), "up 2_create_b") {
+		t.Fatalf("got error %v", err)
+	}
+
+	// The first migration stays applied, and none of the second is left
+	// behind, so the database isn't dirty
+	expectVersion(t, m, 1)
+	if got := tables(t, db); got != "a" {
+		t.Errorf("tables = %q; want a", got)
+	}
+}
+
+// golang-migrate keeps a single (version, dirty) row in
+// schema_migrations, and marks it dirty when a migration fails part way
+func TestGolangMigrateCompatibility(t *testing.T) {
+	db := openTestDB(t)
+	m, err := New(db, testFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A database migrated to version 1 by the migrate CLI
+	_, err = db.ExecContext(t.Context(), `
+		CREATE TABLE schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL);
+		CREATE TABLE a (id int);
+		INSERT INTO schema_migrations (version, dirty) VALUES (1, false)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 1)
+
+	err = m.To(t.Context(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 2)
+	if got := tables(t, db); got != "a b" {
+		t.Errorf("tables = %q; want a b", got)
+	}
+
+	// and the table still has the single row the CLI expects
+	var rows int
+	err = db.QueryRowContext(t.Context(), `SELECT COUNT(*) FROM schema_migrations`).Scan(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("schema_migrations has %d rows; want 1", rows)
+	}
+
+	// A migration the CLI gave up on part way leaves the database dirty,
+	// and nothing more is done until someone fixes it by hand
+	_, err = db.ExecContext(t.Context(), `UPDATE schema_migrations SET version = 3, dirty = true`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, dirty, err := m.Version(t.Context())
+	if err != nil || v != 3 || !dirty {
+		t.Fatalf("version = %d (dirty %v), error %v; want 3, dirty", v, dirty, err)
+	}
+	for name, run := range map[string]func() error{
+		"Up":   func() error { return m.Up(t.Context()) },
+		"Down": func() error { return m.Down(t.Context()) },
+	} {
+		if err := run(); !errors.Is(err, ErrDirty) {
+			t.Errorf("%s: got error %v; want ErrDirty", name, err)
+		}
+	}
+
+	// Forcing it back to 2 (migrate force 2) lets Up carry on
+	_, err = db.ExecContext(t.Context(), `UPDATE schema_migrations SET version = 2, dirty = false`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 3)
+	if got := tables(t, db); got != "a b c" {
+		t.Errorf("tables = %q; want a b c", got)
+	}
+}
@@ -0,0 +1,156 @@
+// Filename: internal/tracing/exporters.go
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans somewhere
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+// StdoutExporter writes each span as one line of JSON
+type StdoutExporter struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+func (e *StdoutExporter) Export(spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.W)
+	for _, span := range spans {
+		line := map[string]any{
+			"name":        span.Name,
+			"trace_id":    span.TraceID.String(),
+			"span_id":     span.SpanID.String(),
+			"start":       span.Start,
+			"duration_ms": float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+			"attributes":  span.Attributes,
+		}
+		if span.ParentSpanID.IsValid() {
+			line["parent_span_id"] = span.ParentSpanID.String()
+		}
+		if span.Error != "" {
+			line["error"] = span.Error
+		}
+		err := enc.Encode(line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding. Endpoint is the collector's base URL, e.g.
+// http://localhost:4318 (spans are sent to Endpoint + "/v1/traces").
+type OTLPExporter struct {
+	Endpoint    string
+	ServiceName string
+	Client      *http.Client
+}
+
+func NewOTLPExporter(endpoint string, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OTLPExporter) Export(spans []SpanData) error {
+	otlpSpans := make([]map[string]any, 0, len(spans))
+	for _, span := range spans {
+		s := map[string]any{
+			"traceId":           span.TraceID.String(),
+			"spanId":            span.SpanID.String(),
+			"name":              span.Name,
+			"kind":              int(span.Kind),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+		}
+		if span.ParentSpanID.IsValid() {
+			s["parentSpanId"] = span.ParentSpanID.String()
+		}
+		if span.Error != "" {
+			s["status"] = map[string]any{"code": 2, "message": span.Error} // STATUS_CODE_ERROR
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	payload := map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": otlpAttributes(map[string]any{"service.name": e.ServiceName}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "github.com/mickali02/qod/internal/tracing"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	res, err := e.Client.Post(e.Endpoint+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("otlp exporter: collector returned %s", res.Status)
+	}
+	return nil
+}
+
+// otlpAttributes converts attributes to the OTLP key/value list format
+func otlpAttributes(attributes map[string]any) []map[string]any {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		var value map[string]any
+		switch v := attributes[key].(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		list = append(list, map[string]any{"key": key, "value": value})
+	}
+	return list
+}
@@ -0,0 +1,186 @@
+// Filename: internal/tracing/exporters_test.go
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSpan is a finished child span with one of each attribute type
+func testSpan(t *testing.T) SpanData {
+	t.Helper()
+
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("bad test traceparent")
+	}
+	start := time.Unix(1700000000, 500)
+	return SpanData{
+		Name:         "GET /v1/comments/:id",
+		Kind:         KindServer,
+		TraceID:      sc.TraceID,
+		SpanID:       SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		ParentSpanID: sc.SpanID,
+		Start:        start,
+		End:          start.Add(1500 * time.Microsecond),
+		Attributes: map[string]any{
+			"url.path":                  "/v1/comments/5",
+			"http.response.status_code": 500,
+			"db.rows":                   int64(3),
+			"cache.hit":                 false,
+			"ratio":                     0.25,
+			"other":                     time.Second,
+		},
+		Error: "500 Internal Server Error",
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		gotPath        string
+		gotContentType string
+		gotBody        []byte
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotContentType = r.Header.Get("Content-Type")
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	// A trailing slash on the endpoint is tidied away
+	exporter := NewOTLPExporter(collector.URL+"/", "qod-test")
+	err := exporter.Export([]SpanData{testSpan(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotPath != "/v1/traces" {
+		t.Errorf("posted to %q; want /v1/traces", gotPath)
+	}
+	if gotContentType != "application/json" {
+		t.Errorf("Content-Type = %q", gotContentType)
+	}
+
+	want := `{
+	"resourceSpans": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "qod-test"}}]},
+		"scopeSpans": [{
+			"scope": {"name": "github.com/mickali02/qod/internal/tracing"},
+			"spans": [{
+				"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"spanId": "0102030405060708",
+				"parentSpanId": "00f067aa0ba902b7",
+				"name": "GET /v1/comments/:id",
+				"kind": 2,
+				"startTimeUnixNano": "1700000000000000500",
+				"endTimeUnixNano": "1700000000001500500",
+				"attributes": [
+					{"key": "cache.hit", "value": {"boolValue": false}},
+					{"key": "db.rows", "value": {"intValue": "3"}},
+					{"key": "http.response.status_code", "value": {"intValue": "500"}},
+					{"key": "other", "value": {"stringValue": "1s"}},
+					{"key": "ratio", "value": {"doubleValue": 0.25}},
+					{"key": "url.path", "value": {"stringValue": "/v1/comments/5"}}
+				],
+				"status": {"code": 2, "message": "500 Internal Server Error"}
+			}]
+		}]
+	}]
+}`
+	assertSameJSON(t, gotBody, []byte(want))
+}
+
+func TestOTLPExporterRootSpan(t *testing.T) {
+	var gotBody []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	span := testSpan(t)
+	span.ParentSpanID = SpanID{}
+	span.Error = ""
+
+	err := NewOTLPExporter(collector.URL, "qod").Export([]SpanData{span})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A root span that succeeded has no parent or status
+	if bytes.Contains(gotBody, []byte("parentSpanId")) || bytes.Contains(gotBody, []byte(`"status"`)) {
+		t.Errorf("unexpected parentSpanId or status in %s", gotBody)
+	}
+}
+
+func TestOTLPExporterCollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	err := NewOTLPExporter(collector.URL, "qod").Export([]SpanData{testSpan(t)})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got error %v; want one mentioning 503", err)
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out bytes.Buffer
+	exporter := &StdoutExporter{W: &out}
+
+	root := testSpan(t)
+	root.ParentSpanID = SpanID{}
+	root.Error = ""
+
+	err := exporter.Export([]SpanData{testSpan(t), root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines; want one per span:\n%s", len(lines), out.String())
+	}
+
+	var child map[string]any
+	err = json.Unmarshal([]byte(lines[0]), &child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || child["parent_span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("child span line = %s", lines[0])
+	}
+	if child["duration_ms"] != 1.5 || child["error"] != "500 Internal Server Error" {
+		t.Errorf("child span line = %s", lines[0])
+	}
+
+	if strings.Contains(lines[1], "parent_span_id") || strings.Contains(lines[1], `"error"`) {
+		t.Errorf("root span line = %s", lines[1])
+	}
+}
+
+// assertSameJSON compares two JSON documents, ignoring layout
+func assertSameJSON(t *testing.T, got []byte, want []byte) {
+	t.Helper()
+
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("invalid expected JSON: %v", err)
+	}
+
+	gotJSON, _ := json.MarshalIndent(g, "", "  ")
+	wantJSON, _ := json.MarshalIndent(w, "", "  ")
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("got:\n%s\nwant:\n%s", gotJSON, wantJSON)
+	}
+}
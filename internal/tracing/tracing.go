@@ -0,0 +1,376 @@
+// Filename: internal/tracing/tracing.go
+
+// Package tracing records spans for requests and database calls. Trace
+// context is passed between services with the W3C traceparent header
+// and finished spans are sent to an Exporter in batches.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// ParseTraceparent reads a W3C traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	// Version 00 has exactly four fields; later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	return sc, true
+}
+
+// Traceparent formats the span context as a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// The kinds of span we create
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Span is one timed operation. All methods are safe to call on a nil
+// span, which is what Start returns when tracing is turned off.
+type Span struct {
+	tracer *Tracer
+
+	mu         sync.Mutex
+	name       string
+	kind       SpanKind
+	spanCtx    SpanContext
+	parentID   SpanID
+	start      time.Time
+	end        time.Time
+	attributes map[string]any
+	errMessage string
+	ended      bool
+}
+
+// SpanContext returns the IDs of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.spanCtx
+}
+
+// SetName renames the span (e.g. once the route is known)
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttribute attaches a key/value pair to the span
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errMessage = err.Error()
+}
+
+// End finishes the span and hands it to the exporter
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.spanCtx.Sampled {
+		s.tracer.enqueue(s.data())
+	}
+}
+
+func (s *Span) data() SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attributes := make(map[string]any, len(s.attributes))
+	for key, value := range s.attributes {
+		attributes[key] = value
+	}
+	return SpanData{
+		Name:         s.name,
+		Kind:         s.kind,
+		TraceID:      s.spanCtx.TraceID,
+		SpanID:       s.spanCtx.SpanID,
+		ParentSpanID: s.parentID,
+		Start:        s.start,
+		End:          s.end,
+		Attributes:   attributes,
+		Error:        s.errMessage,
+	}
+}
+
+// SpanData is a finished span as seen by an exporter
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]any
+	Error        string
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the current span, or nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext records a span context that came from
+// another service (via traceparent) so the next span continues its trace
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// StartOption changes how a span is started
+type StartOption func(*Span)
+
+// WithKind sets the span kind (the default is KindInternal)
+func WithKind(kind SpanKind) StartOption {
+	return func(s *Span) { s.kind = kind }
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultTracer *Tracer
+)
+
+// SetDefault sets the tracer used by Start. Passing nil turns tracing off.
+func SetDefault(t *Tracer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultTracer = t
+}
+
+// Start begins a span with the default tracer. The span is a child of
+// whatever span is in ctx. If tracing is off it returns ctx and a nil span.
+func Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	defaultMu.RLock()
+	t := defaultTracer
+	defaultMu.RUnlock()
+
+	if t == nil {
+		return ctx, nil
+	}
+	return t.Start(ctx, name, opts...)
+}
+
+// Tracer creates spans and sends them to its exporter in batches
+type Tracer struct {
+	exporter Exporter
+	onError  func(error)
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// The batching limits for sending spans to the exporter
+const (
+	queueSize     = 2048
+	maxBatchSize  = 512
+	batchInterval = 5 * time.Second
+)
+
+// New creates a tracer and starts its background exporting goroutine.
+// onError (which may be nil) is called when the exporter fails.
+func New(exporter Exporter, onError func(error)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		onError:  onError,
+		queue:    make(chan SpanData, queueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start begins a span which is a child of the span in ctx (or of a
+// remote parent, or a new trace if there is neither)
+func (t *Tracer) Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	span := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       KindInternal,
+		start:      time.Now(),
+		attributes: make(map[string]any),
+	}
+	for _, opt := range opts {
+		opt(span)
+	}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.spanCtx.TraceID = parent.spanCtx.TraceID
+		span.spanCtx.Sampled = parent.spanCtx.Sampled
+		span.parentID = parent.spanCtx.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		span.spanCtx.TraceID = remote.TraceID
+		span.spanCtx.Sampled = remote.Sampled
+		span.parentID = remote.SpanID
+	} else {
+		rand.Read(span.spanCtx.TraceID[:])
+		span.spanCtx.Sampled = true
+	}
+	rand.Read(span.spanCtx.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case t.queue <- data:
+	case <-t.done:
+	default:
+		// The queue is full; drop the span rather than slow down requests.
+	}
+}
+
+// run collects spans and exports them every batchInterval or whenever
+// a full batch is ready
+func (t *Tracer) run() {
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	export := func() {
+		if len(batch) > 0 {
+			err := t.exporter.Export(batch)
+			if err != nil && t.onError != nil {
+				t.onError(err)
+			}
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= maxBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case reply := <-t.flush:
+			// Drain whatever is already queued then export it
+			for {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+					continue
+				default:
+				}
+				break
+			}
+			export()
+			close(reply)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Shutdown exports any spans still waiting and stops the tracer
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case t.flush <- reply:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	t.stopOnce.Do(func() { close(t.done) })
+	return nil
+}
@@ -0,0 +1,195 @@
+// Filename: internal/tracing/tracing_test.go
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name        string
+		header      string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags kept out of sampled", "00-" + traceID + "-" + spanID + "-02", true, false},
+		{"surrounding space", "  00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"later version with more fields", "cc-" + traceID + "-" + spanID + "-01-extra", true, true},
+		{"empty", "", false, false},
+		{"garbage", "not-a-traceparent", false, false},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"version 00 with extra field", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"long version", "000-" + traceID + "-" + spanID + "-01", false, false},
+		{"short trace ID", "00-" + traceID[:30] + "-" + spanID + "-01", false, false},
+		{"short span ID", "00-" + traceID + "-" + spanID[:14] + "-01", false, false},
+		{"long flags", "00-" + traceID + "-" + spanID + "-001", false, false},
+		{"non-hex trace ID", "00-" + "zz" + traceID[2:] + "-" + spanID + "-01", false, false},
+		{"non-hex span ID", "00-" + traceID + "-" + "zz" + spanID[2:] + "-01", false, false},
+		{"non-hex flags", "00-" + traceID + "-" + spanID + "-zz", false, false},
+		{"all-zero trace ID", "00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"all-zero span ID", "00-" + traceID + "-0000000000000000-01", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.header)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v; want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				if sc != (SpanContext{}) {
+					t.Errorf("got %+v with ok = false; want the zero SpanContext", sc)
+				}
+				return
+			}
+			if sc.TraceID.String() != traceID {
+				t.Errorf("trace ID = %s; want %s", sc.TraceID, traceID)
+			}
+			if sc.SpanID.String() != spanID {
+				t.Errorf("span ID = %s; want %s", sc.SpanID, spanID)
+			}
+			if sc.Sampled != tt.wantSampled {
+				t.Errorf("sampled = %v; want %v", sc.Sampled, tt.wantSampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	headers := []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		"00-ffffffffffffffffffffffffffffffff-ffffffffffffffff-01",
+		"00-00000000000000000000000000000001-0000000000000001-00",
+	}
+
+	for _, header := range headers {
+		sc, ok := ParseTraceparent(header)
+		if !ok {
+			t.Errorf("ParseTraceparent(%q) failed", header)
+			continue
+		}
+		if got := sc.Traceparent(); got != header {
+			t.Errorf("round trip of %q gave %q", header, got)
+		}
+	}
+}
+
+func TestIDValidity(t *testing.T) {
+	if (TraceID{}).IsValid() || (SpanID{}).IsValid() {
+		t.Error("all-zero IDs should not be valid")
+	}
+	if !(TraceID{15: 1}).IsValid() || !(SpanID{7: 1}).IsValid() {
+		t.Error("non-zero IDs should be valid")
+	}
+}
+
+// recorder is an Exporter which keeps the spans it is given
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+	err   error
+}
+
+func (r *recorder) Export(spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return r.err
+}
+
+func TestTracerParentsAndExport(t *testing.T) {
+	exporter := &recorder{}
+	tracer := New(exporter, nil)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+
+	ctx, server := tracer.Start(ctx, "HTTP GET", WithKind(KindServer))
+	_, child := tracer.Start(ctx, "CommentModel.Get")
+	child.SetAttribute("db.rows", 1)
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End() // a second End is ignored
+	server.SetName("GET /v1/comments/:id")
+	server.End()
+
+	shutdown, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(shutdown); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("exported %d spans; want 2", len(exporter.spans))
+	}
+	gotChild, gotServer := exporter.spans[0], exporter.spans[1]
+
+	if gotServer.Name != "GET /v1/comments/:id" || gotServer.Kind != KindServer {
+		t.Errorf("server span = %q kind %d", gotServer.Name, gotServer.Kind)
+	}
+	if gotServer.TraceID != remote.TraceID || gotServer.ParentSpanID != remote.SpanID {
+		t.Error("server span did not continue the remote trace")
+	}
+	if gotChild.TraceID != remote.TraceID || gotChild.ParentSpanID != gotServer.SpanID {
+		t.Error("child span is not a child of the server span")
+	}
+	if gotChild.Error != "boom" || gotChild.Attributes["db.rows"] != 1 {
+		t.Errorf("child span = %+v", gotChild)
+	}
+}
+
+func TestTracerSkipsUnsampledTraces(t *testing.T) {
+	exporter := &recorder{}
+	tracer := New(exporter, nil)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+	_, span := tracer.Start(ctx, "not sampled")
+	span.End()
+
+	tracer.Shutdown(context.Background())
+	if len(exporter.spans) != 0 {
+		t.Errorf("exported %d spans from an unsampled trace", len(exporter.spans))
+	}
+}
+
+func TestTracerReportsExportErrors(t *testing.T) {
+	exporter := &recorder{err: errors.New("collector down")}
+
+	var got error
+	tracer := New(exporter, func(err error) { got = err })
+	_, span := tracer.Start(context.Background(), "span")
+	span.End()
+	tracer.Shutdown(context.Background())
+
+	if got == nil || got.Error() != "collector down" {
+		t.Errorf("onError got %v", got)
+	}
+}
+
+func TestStartWithoutDefaultTracer(t *testing.T) {
+	SetDefault(nil)
+
+	ctx := context.Background()
+	gotCtx, span := Start(ctx, "nothing")
+	if span != nil || gotCtx != ctx {
+		t.Error("Start without a tracer should return ctx and a nil span")
+	}
+
+	// The span methods are safe to call on nil
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("ignored"))
+	span.End()
+}
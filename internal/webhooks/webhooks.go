@@ -0,0 +1,264 @@
+// Filename: internal/webhooks/webhooks.go
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-QOD-Event"
+	HeaderDelivery  = "X-QOD-Delivery"
+	HeaderTimestamp = "X-QOD-Timestamp"
+	HeaderSignature = "X-QOD-Signature"
+)
+
+// Delivery is one event on its way to one webhook
+type Delivery struct {
+	ID        int64
+	WebhookID int64
+	URL       string
+	Secret    string
+	EventType string
+	Payload   []byte
+	Attempt   int // starts at 1
+}
+
+// Attempt is the outcome of trying to send a Delivery
+type Attempt struct {
+	DeliveryID int64
+	Attempt    int
+	StatusCode int // 0 if there was no response
+	Error      string
+	Duration   time.Duration
+	Succeeded  bool
+	// NextAttemptAt is when to try again. It is zero once we have given
+	// up, or on success.
+	NextAttemptAt time.Time
+}
+
+// Store is where the dispatcher finds its work
+type Store interface {
+	// FanOut turns up to limit new outbox events into one delivery per
+	// interested webhook and returns how many events it handled
+	FanOut(ctx context.Context, limit int) (int, error)
+	// Claim returns up to limit deliveries that are due, and hides them
+	// from other callers for lease (in case we die mid-delivery)
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	// Record saves an attempt and the delivery's new state
+	Record(ctx context.Context, attempt Attempt) error
+}
+
+// Sign returns the X-QOD-Signature value for a body sent at timestamp
+// (Unix seconds). Receivers should compute the same value with their
+// copy of the secret and compare using hmac.Equal.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends the deliveries in a Store
+type Dispatcher struct {
+	Store       Store
+	Client      *http.Client  // see NewClient
+	Workers     int           // deliveries sent at the same time
+	MaxAttempts int           // attempts before a delivery is marked failed
+	Backoff     time.Duration // wait before the second attempt; doubles each time
+	MaxBackoff  time.Duration
+	Interval    time.Duration // how often to look for work when not woken
+	// OnError, if set, is told about store errors
+	OnError func(err error)
+	// OnAttempt, if set, is told about every attempt
+	OnAttempt func(delivery Delivery, attempt Attempt)
+}
+
+// Run works until ctx is cancelled, then returns once the deliveries it
+// is sending are done. A send on wake makes it look for work straight
+// away rather than at the next interval.
+func (d *Dispatcher) Run(ctx context.Context, wake <-chan struct{}) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// drain fans out new events and sends what is due until there is nothing
+// left to do
+func (d *Dispatcher) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := d.Store.FanOut(ctx, 100)
+		if err != nil {
+			d.reportError(err)
+			return
+		}
+
+		// Hold a claim for longer than a delivery can take
+		deliveries, err := d.Store.Claim(ctx, d.Workers*4, 2*d.Client.Timeout+time.Minute)
+		if err != nil {
+			d.reportError(err)
+			return
+		}
+
+		if events == 0 && len(deliveries) == 0 {
+			return
+		}
+		d.sendAll(ctx, deliveries)
+	}
+}
+
+// sendAll sends the deliveries using up to d.Workers goroutines
+func (d *Dispatcher) sendAll(ctx context.Context, deliveries []Delivery) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(d.Workers, 1))
+
+	for _, delivery := range deliveries {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			// Finish deliveries that have started even if we are
+			// stopping (Client.Timeout still applies), and record them so
+			// they aren't sent twice
+			ctx := context.WithoutCancel(ctx)
+			attempt := d.Send(ctx, delivery)
+			err := d.Store.Record(ctx, attempt)
+			if err != nil {
+				d.reportError(err)
+			}
+			if d.OnAttempt != nil {
+				d.OnAttempt(delivery, attempt)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Send makes one attempt at a delivery. Any 2xx response is a success.
+func (d *Dispatcher) Send(ctx context.Context, delivery Delivery) Attempt {
+	attempt := Attempt{DeliveryID: delivery.ID, Attempt: delivery.Attempt}
+	start := time.Now()
+
+	err := d.post(ctx, delivery, &attempt)
+	attempt.Duration = time.Since(start)
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	attempt.Succeeded = err == nil && attempt.StatusCode >= 200 && attempt.StatusCode < 300
+	if !attempt.Succeeded && delivery.Attempt < d.MaxAttempts {
+		attempt.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempt))
+	}
+	return attempt
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery Delivery, attempt *Attempt) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "qod-webhooks/1.0")
+	request.Header.Set(HeaderEvent, delivery.EventType)
+	request.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// Read a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	attempt.StatusCode = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("receiver responded with %s", response.Status)
+	}
+	return nil
+}
+
+// backoff returns the wait after the given attempt: Backoff, then twice
+// that, and so on up to MaxBackoff, with some jitter so that failed
+// deliveries don't all come back at once
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := d.Backoff << min(attempt-1, 30)
+	if wait <= 0 || wait > d.MaxBackoff {
+		wait = d.MaxBackoff
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+func (d *Dispatcher) reportError(err error) {
+	if d.OnError != nil && !errors.Is(err, context.Canceled) {
+		d.OnError(err)
+	}
+}
+
+// ErrPrivateAddress is returned when a webhook points inside our network
+var ErrPrivateAddress = errors.New("webhooks: destination is a private address")
+
+// NewClient returns an HTTP client for deliveries. Unless allowPrivate
+// is set it refuses to connect to loopback, private and link-local
+// addresses, so a webhook can't be used to probe our own network.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// A redirect could lead anywhere; treat it as a failure
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,297 @@
+// Filename: internal/webhooks/webhooks_test.go
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryStore is a Store holding deliveries in memory. A delivery is
+// pending until it succeeds or an attempt comes back without a next
+// attempt time, when it has failed.
+type memoryStore struct {
+	mu         sync.Mutex
+	deliveries map[int64]*storedDelivery
+	done       chan int64 // receives a delivery's ID once it has succeeded or failed
+}
+
+type storedDelivery struct {
+	Delivery
+	status   string // pending|succeeded|failed
+	due      time.Time
+	claimed  bool
+	attempts []Attempt
+	recorded []time.Time // when each attempt was recorded
+}
+
+func newMemoryStore(deliveries ...Delivery) *memoryStore {
+	s := &memoryStore{deliveries: make(map[int64]*storedDelivery), done: make(chan int64, len(deliveries))}
+	for _, delivery := range deliveries {
+		delivery.Attempt = 1
+		s.deliveries[delivery.ID] = &storedDelivery{Delivery: delivery, status: "pending"}
+	}
+	return s
+}
+
+func (s *memoryStore) FanOut(ctx context.Context, limit int) (int, error) {
+	return 0, nil
+}
+
+func (s *memoryStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Delivery
+	for _, stored := range s.deliveries {
+		if stored.status == "pending" && !stored.claimed && !time.Now().Before(stored.due) && len(due) < limit {
+			stored.claimed = true
+			due = append(due, stored.Delivery)
+		}
+	}
+	return due, nil
+}
+
+func (s *memoryStore) Record(ctx context.Context, attempt Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.deliveries[attempt.DeliveryID]
+	stored.attempts = append(stored.attempts, attempt)
+	stored.recorded = append(stored.recorded, time.Now())
+	stored.claimed = false
+	switch {
+	case attempt.Succeeded:
+		stored.status = "succeeded"
+	case attempt.NextAttemptAt.IsZero():
+		stored.status = "failed"
+	default:
+		stored.Attempt++
+		stored.due = attempt.NextAttemptAt
+		return nil
+	}
+	s.done <- stored.ID
+	return nil
+}
+
+// get returns a copy of a delivery's state
+func (s *memoryStore) get(id int64) storedDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *s.deliveries[id]
+	stored.attempts = append([]Attempt(nil), stored.attempts...)
+	stored.recorded = append([]time.Time(nil), stored.recorded...)
+	return stored
+}
+
+// runDispatcher runs d until the delivery with the given ID is finished
+func runDispatcher(t *testing.T, d *Dispatcher, store *memoryStore, id int64) storedDelivery {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		d.Run(ctx, nil)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	select {
+	case <-store.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("delivery %d was not finished: %+v", id, store.get(id))
+	}
+	return store.get(id)
+}
+
+func newTestDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		Store:       store,
+		Client:      NewClient(time.Second, true),
+		Workers:     2,
+		MaxAttempts: 3,
+		Backoff:     10 * time.Millisecond,
+		MaxBackoff:  20 * time.Millisecond,
+		Interval:    5 * time.Millisecond,
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.Header, body}
+	}))
+	defer server.Close()
+
+	payload := []byte(`{"type":"comment.created","comment":{"id":7}}`)
+	store := newMemoryStore(Delivery{ID: 42, URL: server.URL, Secret: "s3cret", EventType: "comment.created", Payload: payload})
+	delivery := runDispatcher(t, newTestDispatcher(store), store, 42)
+	if delivery.status != "succeeded" || len(delivery.attempts) != 1 || delivery.attempts[0].StatusCode != http.StatusOK {
+		t.Fatalf("delivery is %+v", delivery)
+	}
+
+	got := <-requests
+	if string(got.body) != string(payload) {
+		t.Errorf("body = %s; want %s", got.body, payload)
+	}
+
+	// The signature is an HMAC-SHA256 of the timestamp and exactly the
+	// bytes that were sent
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(got.header.Get(HeaderTimestamp) + "."))
+	mac.Write(got.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if signature := got.header.Get(HeaderSignature); signature != want {
+		t.Errorf("%s = %q; want %q", HeaderSignature, signature, want)
+	}
+
+	timestamp, err := strconv.ParseInt(got.header.Get(HeaderTimestamp), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+		t.Errorf("%s = %q", HeaderTimestamp, got.header.Get(HeaderTimestamp))
+	}
+	for header, want := range map[string]string{
+		HeaderEvent:    "comment.created",
+		HeaderDelivery: "42",
+		"Content-Type": "application/json",
+	} {
+		if got := got.header.Get(header); got != want {
+			t.Errorf("%s = %q; want %q", header, got, want)
+		}
+	}
+}
+
+func TestDeliveryRetriedAfterServerError(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	store := newMemoryStore(Delivery{ID: 1, URL: server.URL, Secret: "s3cret", Payload: []byte("{}")})
+	d := newTestDispatcher(store)
+	d.MaxAttempts = 5
+	d.Backoff = 40 * time.Millisecond
+	d.MaxBackoff = time.Second
+	delivery := runDispatcher(t, d, store, 1)
+
+	if delivery.status != "succeeded" || len(delivery.attempts) != 3 {
+		t.Fatalf("delivery is %+v", delivery)
+	}
+	for i, attempt := range delivery.attempts[:2] {
+		if attempt.Succeeded || attempt.StatusCode != http.StatusServiceUnavailable || attempt.Attempt != i+1 {
+			t.Errorf("attempt %d is %+v", i+1, attempt)
+		}
+		// The wait doubles from Backoff, with up to half of it taken
+		// off at random (and a little more for the time it took to
+		// record the attempt)
+		wait := d.Backoff << i
+		next := attempt.NextAttemptAt.Sub(delivery.recorded[i])
+		if next < wait/2-10*time.Millisecond || next > wait {
+			t.Errorf("attempt %d waits %v; want between %v and %v", i+1, next, wait/2, wait)
+		}
+		// and the next attempt isn't made before then
+		if retried := delivery.recorded[i+1].Add(-delivery.attempts[i+1].Duration); retried.Before(attempt.NextAttemptAt) {
+			t.Errorf("attempt %d was made %v early", i+2, attempt.NextAttemptAt.Sub(retried))
+		}
+	}
+}
+
+func TestDeliveryFailsAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	store := newMemoryStore(Delivery{ID: 1, URL: server.URL, Secret: "s3cret", Payload: []byte("{}")})
+	d := newTestDispatcher(store)
+	delivery := runDispatcher(t, d, store, 1)
+
+	if delivery.status != "failed" || len(delivery.attempts) != d.MaxAttempts || int(calls.Load()) != d.MaxAttempts {
+		t.Fatalf("delivery is %+v after %d requests", delivery, calls.Load())
+	}
+	last := delivery.attempts[len(delivery.attempts)-1]
+	if !last.NextAttemptAt.IsZero() || last.Error == "" {
+		t.Errorf("last attempt is %+v", last)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		wait    time.Duration // before jitter
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := d.backoff(tt.attempt)
+			if got < tt.wait/2 || got > tt.wait {
+				t.Errorf("backoff(%d) = %v; want between %v and %v", tt.attempt, got, tt.wait/2, tt.wait)
+				break
+			}
+		}
+	}
+}
+
+func TestNewClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	_, err := NewClient(time.Second, false).Get(server.URL)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("got error %v; want ErrPrivateAddress", err)
+	}
+
+	client := NewClient(time.Second, true)
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status = %d", response.StatusCode)
+	}
+
+	// Redirects aren't followed
+	response, err = client.Get(server.URL + "/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		t.Errorf("redirect: status = %d", response.StatusCode)
+	}
+}
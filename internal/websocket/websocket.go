@@ -0,0 +1,339 @@
+// Filename: internal/websocket/websocket.go
+
+// Package websocket is a small server side implementation of RFC 6455.
+// It covers what the API needs: the opening handshake, text and binary
+// messages (including fragmented ones), ping/pong and the closing
+// handshake. Extensions such as compression are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidData     = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseTryAgainLater   = 1013
+)
+
+// acceptGUID is appended to the client's key to prove we speak WebSocket
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrMessageTooBig is returned by ReadMessage for messages over the limit
+var ErrMessageTooBig = errors.New("websocket: message too big")
+
+// CloseError is returned by ReadMessage once the peer has closed the
+// connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed by peer (%d %s)", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others
+// write; writes are serialised.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// MaxMessageSize is the largest message ReadMessage accepts
+	MaxMessageSize int64
+	// PongHandler, if set, is called for every pong received
+	PongHandler func()
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	closeSent bool
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// On failure it has already written an error response.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(status int, message string) (*Conn, error) {
+		http.Error(w, message, status)
+		return nil, errors.New("websocket: " + message)
+	}
+
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "handshake must use GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "connection cannot be upgraded")
+	}
+
+	// Any deadlines set by the server no longer apply
+	netConn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	_, err = netConn.Write([]byte(response))
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: netConn, reader: rw.Reader, MaxMessageSize: 64 * 1024}, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains reports whether a comma separated header has token
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadDeadline limits how long ReadMessage may wait
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next text or binary message. Pings are
+// answered and pongs passed to PongHandler along the way. Once the peer
+// closes the connection it returns a *CloseError.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var (
+		opcode  = -1
+		message []byte
+	)
+
+	for {
+		frame, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame.opcode {
+		case OpPing:
+			err = c.WriteControl(OpPong, frame.payload, time.Now().Add(5*time.Second))
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			if c.PongHandler != nil {
+				c.PongHandler()
+			}
+			continue
+		case OpClose:
+			closeErr := &CloseError{Code: 1005} // no status received
+			if len(frame.payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(frame.payload))
+				closeErr.Reason = string(frame.payload[2:])
+			}
+			// Echo the close back, as the closing handshake requires
+			c.Close(CloseNormal, "")
+			return 0, nil, closeErr
+		case OpText, OpBinary:
+			if opcode != -1 {
+				return 0, nil, c.fail(CloseProtocolError, "expected a continuation frame")
+			}
+			opcode = frame.opcode
+		case OpContinuation:
+			if opcode == -1 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(message))+int64(len(frame.payload)) > c.MaxMessageSize {
+			c.fail(CloseMessageTooBig, "message too big")
+			return 0, nil, ErrMessageTooBig
+		}
+		message = append(message, frame.payload...)
+
+		if frame.fin {
+			if opcode == OpText && !utf8.Valid(message) {
+				return 0, nil, c.fail(CloseInvalidData, "invalid UTF-8")
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return frame{}, err
+	}
+
+	f := frame{fin: header[0]&0x80 != 0, opcode: int(header[0] & 0x0F)}
+	if header[0]&0x70 != 0 {
+		return frame{}, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	// Clients must mask everything they send
+	if header[1]&0x80 == 0 {
+		return frame{}, c.fail(CloseProtocolError, "frame not masked")
+	}
+
+	length := int64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		length = int64(binary.BigEndian.Uint64(extended[:]) & (1<<63 - 1))
+	}
+	if err != nil {
+		return frame{}, err
+	}
+
+	if f.opcode >= OpClose && (length > 125 || !f.fin) {
+		return frame{}, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length > c.MaxMessageSize {
+		c.fail(CloseMessageTooBig, "message too big")
+		return frame{}, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(c.reader, mask[:])
+	if err != nil {
+		return frame{}, err
+	}
+
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, f.payload)
+	if err != nil {
+		return frame{}, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// WriteMessage sends a text or binary message, giving up at deadline
+func (c *Conn) WriteMessage(opcode int, data []byte, deadline time.Time) error {
+	return c.writeFrame(opcode, data, deadline)
+}
+
+// WriteControl sends a ping, pong or close frame
+func (c *Conn) WriteControl(opcode int, data []byte, deadline time.Time) error {
+	if len(data) > 125 {
+		return errors.New("websocket: control frame payload too long")
+	}
+	return c.writeFrame(opcode, data, deadline)
+}
+
+func (c *Conn) writeFrame(opcode int, data []byte, deadline time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return net.ErrClosed
+	}
+
+	// Servers never mask, so the header is all we add
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|byte(opcode))
+	switch {
+	case len(data) <= 125:
+		header = append(header, byte(len(data)))
+	case len(data) <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(data)))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(data)))
+	}
+
+	c.conn.SetWriteDeadline(deadline)
+	_, err := (&net.Buffers{header, data}).WriteTo(c.conn)
+	if opcode == OpClose {
+		c.closeSent = true
+	}
+	return err
+}
+
+// Close sends a close frame (if we haven't yet) and closes the
+// connection. It is safe to call more than once.
+func (c *Conn) Close(code int, reason string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > 125 {
+			payload = payload[:125]
+		}
+		c.WriteControl(OpClose, payload, time.Now().Add(time.Second))
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// fail closes the connection because the peer broke the protocol
+func (c *Conn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
@@ -0,0 +1,404 @@
+// Filename: internal/websocket/websocket_test.go
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The examples from RFC 6455 sections 1.3 and 5.7
+const (
+	exampleKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	exampleAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+var exampleMask = [4]byte{0x37, 0xfa, 0x21, 0x3d}
+
+func TestAcceptKey(t *testing.T) {
+	if got := acceptKey(exampleKey); got != exampleAccept {
+		t.Errorf("acceptKey(%q) = %q; want %q", exampleKey, got, exampleAccept)
+	}
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	valid := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/v1/ws", nil)
+		r.Header.Set("Connection", "keep-alive, Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", exampleKey)
+		return r
+	}
+
+	tests := []struct {
+		name       string
+		change     func(r *http.Request)
+		wantStatus int
+	}{
+		{"not GET", func(r *http.Request) { r.Method = http.MethodPost }, http.StatusMethodNotAllowed},
+		{"no Connection: upgrade", func(r *http.Request) { r.Header.Set("Connection", "keep-alive") }, http.StatusBadRequest},
+		{"no Upgrade header", func(r *http.Request) { r.Header.Del("Upgrade") }, http.StatusBadRequest},
+		{"old version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired},
+		{"key isn't base64", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "not base64!") }, http.StatusBadRequest},
+		{"key is the wrong size", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=") }, http.StatusBadRequest},
+		// A ResponseRecorder can't be hijacked
+		{"cannot hijack", func(r *http.Request) {}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.change(r)
+			w := httptest.NewRecorder()
+
+			conn, err := Upgrade(w, r)
+			if err == nil || conn != nil {
+				t.Fatalf("Upgrade succeeded")
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUpgradeRequired && w.Header().Get("Sec-WebSocket-Version") != "13" {
+				t.Error("426 response doesn't say which version we support")
+			}
+		})
+	}
+}
+
+// testClient is the client end of a connection to an echo server
+type testClient struct {
+	t         *testing.T
+	conn      net.Conn
+	reader    *bufio.Reader
+	serverErr chan error // why the server's read loop stopped
+}
+
+// dial starts a server that echoes every message back and completes the
+// opening handshake with it
+func dial(t *testing.T) *testClient {
+	t.Helper()
+
+	serverErr := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		for {
+			opcode, message, err := conn.ReadMessage()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			err = conn.WriteMessage(opcode, message, time.Now().Add(time.Second))
+			if err != nil {
+				serverErr <- err
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := "GET /v1/ws HTTP/1.1\r\n" +
+		"Host: " + server.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + exampleKey + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	_, err = io.WriteString(conn, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d", res.StatusCode)
+	}
+	if got := res.Header.Get("Sec-WebSocket-Accept"); got != exampleAccept {
+		t.Fatalf("Sec-WebSocket-Accept = %q; want %q", got, exampleAccept)
+	}
+	if !strings.EqualFold(res.Header.Get("Upgrade"), "websocket") || !strings.EqualFold(res.Header.Get("Connection"), "upgrade") {
+		t.Fatalf("handshake headers = %v", res.Header)
+	}
+
+	return &testClient{t: t, conn: conn, reader: reader, serverErr: serverErr}
+}
+
+// maskedFrame builds a frame the way a client must send it
+func maskedFrame(fin bool, opcode int, payload []byte) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, exampleMask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^exampleMask[i%4])
+	}
+	return frame
+}
+
+// send writes raw bytes to the server
+func (c *testClient) send(b []byte) {
+	c.t.Helper()
+
+	_, err := c.conn.Write(b)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// receive reads one frame from the server, which must not be masked
+func (c *testClient) receive() (bool, int, []byte) {
+	c.t.Helper()
+
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		c.t.Fatal("server sent a masked frame")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return header[0]&0x80 != 0, int(header[0] & 0x0F), payload
+}
+
+// expectMessage checks the next frame is a whole message
+func (c *testClient) expectMessage(opcode int, payload []byte) {
+	c.t.Helper()
+
+	fin, gotOpcode, got := c.receive()
+	if !fin || gotOpcode != opcode || !bytes.Equal(got, payload) {
+		c.t.Fatalf("got frame fin=%v opcode=%#x payload %q; want opcode %#x payload %q",
+			fin, gotOpcode, truncate(got), opcode, truncate(payload))
+	}
+}
+
+// expectClose checks the server closed the connection with code, and
+// returns why its read loop stopped
+func (c *testClient) expectClose(code int) error {
+	c.t.Helper()
+
+	_, opcode, payload := c.receive()
+	if opcode != OpClose || len(payload) < 2 {
+		c.t.Fatalf("got opcode %#x payload %q; want a close frame", opcode, payload)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		c.t.Errorf("close code = %d (%s); want %d", got, payload[2:], code)
+	}
+
+	select {
+	case err := <-c.serverErr:
+		return err
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("the server didn't stop reading")
+		return nil
+	}
+}
+
+func truncate(b []byte) []byte {
+	if len(b) > 32 {
+		return b[:32]
+	}
+	return b
+}
+
+func TestEcho(t *testing.T) {
+	c := dial(t)
+
+	// RFC 6455 section 5.7: a masked "Hello" and the unmasked reply
+	c.send([]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58})
+	reply := make([]byte, 7)
+	_, err := io.ReadFull(c.reader, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}; !bytes.Equal(reply, want) {
+		t.Errorf("got % x; want % x", reply, want)
+	}
+
+	c.send(maskedFrame(true, OpBinary, []byte{0, 1, 2, 0xff}))
+	c.expectMessage(OpBinary, []byte{0, 1, 2, 0xff})
+
+	c.send(maskedFrame(true, OpText, nil))
+	c.expectMessage(OpText, []byte{})
+}
+
+func TestPayloadLengths(t *testing.T) {
+	c := dial(t)
+
+	// 7 bit, 16 bit and 64 bit lengths, up to MaxMessageSize
+	for _, size := range []int{125, 126, 0xFFFF, 64 * 1024} {
+		payload := bytes.Repeat([]byte("x"), size)
+		c.send(maskedFrame(true, OpText, payload))
+		c.expectMessage(OpText, payload)
+	}
+}
+
+func TestFragmentedMessage(t *testing.T) {
+	c := dial(t)
+
+	// Control frames may come between the fragments of a message
+	c.send(maskedFrame(false, OpText, []byte("Hel")))
+	c.send(maskedFrame(true, OpPing, []byte("are you there?")))
+	c.send(maskedFrame(false, OpContinuation, []byte("lo, ")))
+	c.send(maskedFrame(true, OpContinuation, []byte("world")))
+
+	c.expectMessage(OpPong, []byte("are you there?"))
+	c.expectMessage(OpText, []byte("Hello, world"))
+}
+
+func TestClosingHandshake(t *testing.T) {
+	c := dial(t)
+
+	payload := binary.BigEndian.AppendUint16(nil, CloseGoingAway)
+	payload = append(payload, "bye"...)
+	c.send(maskedFrame(true, OpClose, payload))
+
+	// The close is echoed with a normal closure
+	err := c.expectClose(CloseNormal)
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Reason != "bye" {
+		t.Errorf("ReadMessage returned %v; want the peer's close", err)
+	}
+
+	// and then the connection is closed
+	_, err = c.reader.ReadByte()
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("read after close: %v", err)
+	}
+}
+
+func TestCloseWithoutStatus(t *testing.T) {
+	c := dial(t)
+
+	c.send(maskedFrame(true, OpClose, nil))
+	err := c.expectClose(CloseNormal)
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != 1005 {
+		t.Errorf("ReadMessage returned %v; want close code 1005", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	unmasked := []byte{0x81, 0x02, 'h', 'i'}
+	reservedBits := maskedFrame(true, OpText, []byte("hi"))
+	reservedBits[0] |= 0x40
+	longPing := maskedFrame(true, OpPing, bytes.Repeat([]byte("x"), 126))
+	fragmentedPing := maskedFrame(false, OpPing, nil)
+	unknownOpcode := maskedFrame(true, 0x3, nil)
+	continuation := maskedFrame(true, OpContinuation, []byte("hi"))
+	interrupted := append(maskedFrame(false, OpText, []byte("Hel")), maskedFrame(true, OpText, []byte("lo"))...)
+	invalidUTF8 := maskedFrame(true, OpText, []byte{0xff, 0xfe})
+	tooBig := maskedFrame(true, OpBinary, make([]byte, 64*1024+1))
+	tooBigInFragments := append(maskedFrame(false, OpBinary, make([]byte, 40*1024)), maskedFrame(true, OpContinuation, make([]byte, 40*1024))...)
+
+	tests := []struct {
+		name     string
+		send     []byte
+		wantCode int
+		wantErr  string
+	}{
+		{"unmasked frame", unmasked, CloseProtocolError, "frame not masked"},
+		{"reserved bits", reservedBits, CloseProtocolError, "reserved bits set"},
+		{"control frame over 125 bytes", longPing, CloseProtocolError, "invalid control frame"},
+		{"fragmented control frame", fragmentedPing, CloseProtocolError, "invalid control frame"},
+		{"unknown opcode", unknownOpcode, CloseProtocolError, "unknown opcode"},
+		{"continuation without a start", continuation, CloseProtocolError, "unexpected continuation frame"},
+		{"new message before the last ended", interrupted, CloseProtocolError, "expected a continuation frame"},
+		{"invalid UTF-8", invalidUTF8, CloseInvalidData, "invalid UTF-8"},
+		{"frame too big", tooBig, CloseMessageTooBig, ErrMessageTooBig.Error()},
+		{"message too big", tooBigInFragments, CloseMessageTooBig, ErrMessageTooBig.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t)
+
+			// The server may close before reading all of a big frame
+			go c.conn.Write(tt.send)
+
+			err := c.expectClose(tt.wantCode)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadMessage returned %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteControlTooLong(t *testing.T) {
+	var c Conn
+	err := c.WriteControl(OpPing, make([]byte, 126), time.Now())
+	if err == nil {
+		t.Error("WriteControl accepted a 126 byte payload")
+	}
+}
+
+func TestHeaderContains(t *testing.T) {
+	header := http.Header{}
+	header.Add("Connection", "keep-alive, Upgrade")
+	header.Add("Upgrade", "h2c")
+	header.Add("Upgrade", "WebSocket")
+
+	if !headerContains(header, "Connection", "upgrade") {
+		t.Error("token in a list was missed")
+	}
+	if !headerContains(header, "Upgrade", "websocket") {
+		t.Error("token in a second header line was missed")
+	}
+	if headerContains(header, "Connection", "close") || headerContains(header, "Missing", "x") {
+		t.Error("found a token that isn't there")
+	}
+}
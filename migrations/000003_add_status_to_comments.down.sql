@@ -0,0 +1,4 @@
+-- Filename: migrations/000003_add_status_to_comments.down.sql
+DROP INDEX IF EXISTS comments_status_idx;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_status_check;
+ALTER TABLE comments DROP COLUMN IF EXISTS status;
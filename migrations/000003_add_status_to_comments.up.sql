@@ -0,0 +1,4 @@
+-- Filename: migrations/000003_add_status_to_comments.up.sql
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'published';
+ALTER TABLE comments ADD CONSTRAINT comments_status_check CHECK (status IN ('published', 'pending', 'rejected'));
+CREATE INDEX IF NOT EXISTS comments_status_idx ON comments (status);
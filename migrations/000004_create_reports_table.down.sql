@@ -0,0 +1,2 @@
+-- Filename: migrations/000004_create_reports_table.down.sql
+DROP TABLE IF EXISTS reports;
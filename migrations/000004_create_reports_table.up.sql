@@ -0,0 +1,15 @@
+-- Filename: migrations/000004_create_reports_table.up.sql
+CREATE TABLE IF NOT EXISTS reports (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    reason text NOT NULL,
+    details text NOT NULL DEFAULT '',
+    reporter text NOT NULL,
+    resolved_at timestamp(0) WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS reports_reason_idx ON reports (reason);
+-- Reports are resolved when a moderator decides on the comment, so only
+-- reports filed since then count towards hiding it again, and a reader
+-- may report it again
+CREATE UNIQUE INDEX IF NOT EXISTS reports_comment_reporter_open_idx ON reports (comment_id, reporter) WHERE resolved_at IS NULL;
@@ -0,0 +1,2 @@
+-- Filename: migrations/000005_create_rate_limit_hits_table.down.sql
+DROP TABLE IF EXISTS rate_limit_hits;
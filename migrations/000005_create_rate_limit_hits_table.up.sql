@@ -0,0 +1,7 @@
+-- Filename: migrations/000005_create_rate_limit_hits_table.up.sql
+-- Rate limit state is cheap to lose, so skip the WAL.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_hits (
+    key text NOT NULL,
+    hit_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+CREATE INDEX IF NOT EXISTS rate_limit_hits_key_hit_at_idx ON rate_limit_hits (key, hit_at);
@@ -0,0 +1,2 @@
+-- Filename: migrations/000006_create_comment_events_table.down.sql
+DROP TABLE IF EXISTS comment_events;
@@ -0,0 +1,17 @@
+-- Filename: migrations/000006_create_comment_events_table.up.sql
+-- A log of comment changes, so a client of the live feed can pick up
+-- where it left off after reconnecting. previous_status is what an
+-- update changed the status from, so the feed can tell a published
+-- comment being hidden from a pending one being edited.
+CREATE TABLE IF NOT EXISTS comment_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    type text NOT NULL,
+    comment_id bigint NOT NULL,
+    content text NOT NULL DEFAULT '',
+    author text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT '',
+    previous_status text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS comment_events_created_at_idx ON comment_events (created_at);
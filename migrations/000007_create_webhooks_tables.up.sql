@@ -0,0 +1,50 @@
+-- Filename: migrations/000007_create_webhooks_tables.up.sql
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL DEFAULT '{}', -- empty means every event
+    active boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Written in the same transaction as the comment change, so an event is
+-- never lost or sent for a change that was rolled back
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    processed_at timestamp(6) WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS webhook_outbox_unprocessed_idx ON webhook_outbox (id) WHERE processed_at IS NULL;
+CREATE INDEX IF NOT EXISTS webhook_outbox_processed_at_idx ON webhook_outbox (processed_at) WHERE processed_at IS NOT NULL;
+
+-- One row for each event sent to each webhook
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    outbox_id bigint NOT NULL REFERENCES webhook_outbox ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id);
+-- Pruning the outbox looks up (and cascades to) each event's deliveries
+CREATE INDEX IF NOT EXISTS webhook_deliveries_outbox_id_idx ON webhook_deliveries (outbox_id);
+
+-- The delivery log: every attempt and how it went
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+    id bigserial PRIMARY KEY,
+    delivery_id bigint NOT NULL REFERENCES webhook_deliveries ON DELETE CASCADE,
+    attempted_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    attempt integer NOT NULL,
+    status_code integer NOT NULL DEFAULT 0,
+    error text NOT NULL DEFAULT '',
+    duration_ms integer NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS webhook_delivery_attempts_delivery_id_idx ON webhook_delivery_attempts (delivery_id);
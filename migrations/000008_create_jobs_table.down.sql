@@ -0,0 +1,2 @@
+-- Filename: migrations/000008_create_jobs_table.down.sql
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,19 @@
+-- Filename: migrations/000008_create_jobs_table.up.sql
+-- The background job queue. Finished jobs are deleted; jobs that run
+-- out of attempts stay behind as 'dead' so they can be looked at.
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    key text, -- at most one live job per key
+    payload jsonb NOT NULL DEFAULT '{}',
+    status text NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'dead')),
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5,
+    run_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until timestamp(6) WITH TIME ZONE,
+    last_error text NOT NULL DEFAULT '',
+    updated_at timestamp(6) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (run_at) WHERE status <> 'dead';
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_key_idx ON jobs (key) WHERE key IS NOT NULL AND status <> 'dead';
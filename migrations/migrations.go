@@ -0,0 +1,10 @@
+// Filename: migrations/migrations.go
+
+// Package migrations embeds the SQL migration files so the API binary
+// can apply them itself (see internal/migrate).
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS